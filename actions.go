@@ -0,0 +1,613 @@
+// This file contains the actions of the viewer and the inputs they are bound
+// to.
+//
+// Each action has a name that is used for its bindings in the config file. An
+// action can be bound to one input of each device: a keyboard key, a mouse
+// input and a game controller input. Most actions happen when their button is
+// pressed. The analog actions happen continuously:
+//
+//   - Orbit is bound to a mouse button to drag with, or a controller stick.
+//     In fly mode it looks around instead.
+//   - Pan is bound to a mouse button to drag with, or a controller stick.
+//   - Zoom is bound to the mouse wheel, or a controller axis.
+//   - FlyMove is bound to a controller stick. It moves in fly mode.
+//
+// The fly actions, FlyForward to FlyDown and FlyMove, happen while their
+// inputs are held in fly mode. They may share inputs with the other actions,
+// which don't get those inputs in fly mode.
+//
+// Inputs are named with SDL names. Keys are SDL key names like "T" or "Right".
+// Mouse inputs are "left", "middle", "right" and "wheel". Controller buttons
+// and axes are SDL game controller names like "a", "leftshoulder" or "righty",
+// and the sticks are "left" and "right".
+//
+// The keys of the map picker, map browser, image viewer and prop placement are
+// not actions. They only apply while those are open.
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+type Action string
+
+const (
+	ActionQuit               Action = "Quit"
+	ActionToggleHelp         Action = "ToggleHelp"
+	ActionToggleProjection   Action = "ToggleProjection"
+	ActionToggleTexture      Action = "ToggleTexture"
+	ActionToggleFilter       Action = "ToggleFilter"
+	ActionCycleSupersampling Action = "CycleSupersampling"
+	ActionToggleLighting     Action = "ToggleLighting"
+	ActionToggleGouraud      Action = "ToggleGouraud"
+	ActionToggleWireframe    Action = "ToggleWireframe"
+	ActionToggleBackground   Action = "ToggleBackground"
+	ActionToggleAutorotate   Action = "ToggleAutorotate"
+	ActionPrevMap            Action = "PrevMap"
+	ActionNextMap            Action = "NextMap"
+	ActionNextScenario       Action = "NextScenario"
+	ActionPlaceProps         Action = "PlaceProps"
+	ActionImages             Action = "Images"
+	ActionFindMap            Action = "FindMap"
+	ActionBrowseMaps         Action = "BrowseMaps"
+	ActionToggleFly          Action = "ToggleFly"
+	ActionToggleGameCamera   Action = "ToggleGameCamera"
+	ActionTurnLeft           Action = "TurnLeft"
+	ActionTurnRight          Action = "TurnRight"
+	ActionToggleTilt         Action = "ToggleTilt"
+	ActionFitView            Action = "FitView"
+
+	// Fly actions. See fly.go.
+	ActionFlyForward Action = "FlyForward"
+	ActionFlyBack    Action = "FlyBack"
+	ActionFlyLeft    Action = "FlyLeft"
+	ActionFlyRight   Action = "FlyRight"
+	ActionFlyUp      Action = "FlyUp"
+	ActionFlyDown    Action = "FlyDown"
+
+	// Analog actions
+	ActionOrbit   Action = "Orbit"
+	ActionPan     Action = "Pan"
+	ActionZoom    Action = "Zoom"
+	ActionFlyMove Action = "FlyMove"
+)
+
+const (
+	// Controller axes are -32768 to 32767. Values closer to the center than
+	// the deadzone are ignored because sticks rarely rest at exactly zero.
+	controllerDeadzone = 8000
+
+	stickOrbitSpeed = 8.0  // Mouse pixels per frame at full tilt
+	stickPanSpeed   = 400  // Mouse pixels per second at full tilt
+	stickZoomSpeed  = 10.0 // Wheel steps per second at full tilt
+)
+
+type inputDevice int
+
+const (
+	inputKey inputDevice = iota
+	inputMouseButton
+	inputMouseWheel
+	inputControllerButton
+	inputControllerAxis
+	inputControllerStick
+)
+
+// Input is a key, mouse input or controller input. The code is the keycode,
+// button, axis or stick.
+type Input struct {
+	device inputDevice
+	code   int
+}
+
+// Controller sticks
+const (
+	stickLeft = iota
+	stickRight
+)
+
+func keyInput(key sdl.Keycode) Input {
+	return Input{inputKey, int(key)}
+}
+
+func mouseButtonInput(button uint8) Input {
+	return Input{inputMouseButton, int(button)}
+}
+
+func controllerButtonInput(button sdl.GameControllerButton) Input {
+	return Input{inputControllerButton, int(button)}
+}
+
+// defaultBindings are the bindings without a config file.
+var defaultBindings = map[Action][]Input{
+	ActionQuit:               {keyInput(sdl.K_ESCAPE)},
+	ActionToggleHelp:         {keyInput(sdl.K_h), controllerButtonInput(sdl.CONTROLLER_BUTTON_BACK)},
+	ActionToggleProjection:   {keyInput(sdl.K_p), controllerButtonInput(sdl.CONTROLLER_BUTTON_X)},
+	ActionToggleTexture:      {keyInput(sdl.K_t)},
+	ActionToggleFilter:       {keyInput(sdl.K_f)},
+	ActionCycleSupersampling: {keyInput(sdl.K_s)},
+	ActionToggleLighting:     {keyInput(sdl.K_l)},
+	ActionToggleGouraud:      {keyInput(sdl.K_g)},
+	ActionToggleWireframe:    {keyInput(sdl.K_w)},
+	ActionToggleBackground:   {keyInput(sdl.K_b), controllerButtonInput(sdl.CONTROLLER_BUTTON_B)},
+	ActionToggleAutorotate:   {keyInput(sdl.K_a), controllerButtonInput(sdl.CONTROLLER_BUTTON_A)},
+	ActionPrevMap:            {keyInput(sdl.K_j), controllerButtonInput(sdl.CONTROLLER_BUTTON_LEFTSHOULDER)},
+	ActionNextMap:            {keyInput(sdl.K_k), controllerButtonInput(sdl.CONTROLLER_BUTTON_RIGHTSHOULDER)},
+	ActionNextScenario:       {keyInput(sdl.K_n), controllerButtonInput(sdl.CONTROLLER_BUTTON_Y)},
+	ActionPlaceProps:         {keyInput(sdl.K_o)},
+	ActionImages:             {keyInput(sdl.K_i)},
+	ActionFindMap:            {keyInput(sdl.K_m)},
+	ActionBrowseMaps:         {keyInput(sdl.K_v)},
+	ActionToggleFly:          {keyInput(sdl.K_c)},
+	ActionToggleGameCamera:   {keyInput(sdl.K_x)},
+	ActionTurnLeft:           {keyInput(sdl.K_q), controllerButtonInput(sdl.CONTROLLER_BUTTON_DPAD_LEFT)},
+	ActionTurnRight:          {keyInput(sdl.K_e), controllerButtonInput(sdl.CONTROLLER_BUTTON_DPAD_RIGHT)},
+	ActionToggleTilt:         {keyInput(sdl.K_r), controllerButtonInput(sdl.CONTROLLER_BUTTON_DPAD_UP)},
+	ActionFitView:            {keyInput(sdl.K_z), controllerButtonInput(sdl.CONTROLLER_BUTTON_RIGHTSTICK)},
+	ActionFlyForward:         {keyInput(sdl.K_w)},
+	ActionFlyBack:            {keyInput(sdl.K_s)},
+	ActionFlyLeft:            {keyInput(sdl.K_a)},
+	ActionFlyRight:           {keyInput(sdl.K_d)},
+	ActionFlyUp:              {keyInput(sdl.K_SPACE), controllerButtonInput(sdl.CONTROLLER_BUTTON_DPAD_UP)},
+	ActionFlyDown:            {keyInput(sdl.K_LCTRL), controllerButtonInput(sdl.CONTROLLER_BUTTON_DPAD_DOWN)},
+	ActionOrbit:              {mouseButtonInput(sdl.BUTTON_LEFT), {inputControllerStick, stickLeft}},
+	ActionPan:                {mouseButtonInput(sdl.BUTTON_RIGHT)},
+	ActionZoom:               {{inputMouseWheel, 0}, {inputControllerAxis, int(sdl.CONTROLLER_AXIS_RIGHTY)}},
+	ActionFlyMove:            {{inputControllerStick, stickRight}},
+}
+
+// isFlyAction returns true for the actions of fly mode.
+func isFlyAction(action Action) bool {
+	_, ok := flyDirections[action]
+	return ok || action == ActionFlyMove
+}
+
+var mouseButtonNames = map[int]string{
+	sdl.BUTTON_LEFT:   "left",
+	sdl.BUTTON_MIDDLE: "middle",
+	sdl.BUTTON_RIGHT:  "right",
+}
+
+var stickNames = map[int]string{
+	stickLeft:  "left",
+	stickRight: "right",
+}
+
+// name returns the name of an input for the config file.
+func (in Input) name() string {
+	switch in.device {
+	case inputKey:
+		return sdl.GetKeyName(sdl.Keycode(in.code))
+	case inputMouseButton:
+		return mouseButtonNames[in.code]
+	case inputMouseWheel:
+		return "wheel"
+	case inputControllerButton:
+		return sdl.GameControllerGetStringForButton(sdl.GameControllerButton(in.code))
+	case inputControllerAxis:
+		return sdl.GameControllerGetStringForAxis(sdl.GameControllerAxis(in.code))
+	case inputControllerStick:
+		return stickNames[in.code]
+	}
+	return ""
+}
+
+// parseKeyInput returns the key input of an action.
+func parseKeyInput(action Action, name string) (Input, error) {
+	if action == ActionOrbit || action == ActionPan || action == ActionZoom || action == ActionFlyMove {
+		return Input{}, fmt.Errorf("%s can't be bound to a key", action)
+	}
+	key := sdl.GetKeyFromName(name)
+	if key == sdl.K_UNKNOWN {
+		return Input{}, fmt.Errorf("unknown key %q for %s", name, action)
+	}
+	return keyInput(key), nil
+}
+
+// parseMouseInput returns the mouse input of an action. Orbit and Pan are
+// bound to the button that is held while dragging.
+func parseMouseInput(action Action, name string) (Input, error) {
+	if name == "wheel" && action == ActionZoom {
+		return Input{inputMouseWheel, 0}, nil
+	}
+	for button, buttonName := range mouseButtonNames {
+		if name == buttonName && action != ActionZoom && action != ActionFlyMove {
+			return Input{inputMouseButton, button}, nil
+		}
+	}
+	return Input{}, fmt.Errorf("can't bind mouse %q to %s", name, action)
+}
+
+// parseControllerInput returns the controller input of an action.
+func parseControllerInput(action Action, name string) (Input, error) {
+	switch action {
+	case ActionOrbit, ActionPan, ActionFlyMove:
+		for stick, stickName := range stickNames {
+			if name == stickName {
+				return Input{inputControllerStick, stick}, nil
+			}
+		}
+	case ActionZoom:
+		if axis := sdl.GameControllerGetAxisFromString(name); axis != sdl.CONTROLLER_AXIS_INVALID {
+			return Input{inputControllerAxis, int(axis)}, nil
+		}
+	default:
+		if button := sdl.GameControllerGetButtonFromString(name); button != sdl.CONTROLLER_BUTTON_INVALID {
+			return controllerButtonInput(button), nil
+		}
+	}
+	return Input{}, fmt.Errorf("can't bind controller %q to %s", name, action)
+}
+
+// parseBindings returns the bindings of the names of the keys, mouse inputs
+// and controller inputs of actions. An empty name leaves the action unbound
+// on that device. An input can only be bound to one action by the names, and
+// to one fly action.
+//
+// Actions without a name on a device keep their default binding, unless the
+// names bind its input to another action. Then the named action wins.
+func parseBindings(keys, mouse, controller map[Action]string) (map[Action][]Input, error) {
+	// Fly actions are bound separately because they only apply in fly mode.
+	type slot struct {
+		input Input
+		fly   bool
+	}
+	bindings := make(map[Action][]Input)
+	bound := make(map[slot]Action)
+	devices := []struct {
+		names map[Action]string
+		parse func(Action, string) (Input, error)
+	}{
+		{keys, parseKeyInput},
+		{mouse, parseMouseInput},
+		{controller, parseControllerInput},
+	}
+	for _, device := range devices {
+		for action, name := range device.names {
+			if _, ok := defaultBindings[action]; !ok {
+				return nil, fmt.Errorf("unknown action %q", action)
+			}
+			if name == "" {
+				continue
+			}
+			input, err := device.parse(action, name)
+			if err != nil {
+				return nil, err
+			}
+			s := slot{input, isFlyAction(action)}
+			if other, ok := bound[s]; ok {
+				return nil, fmt.Errorf("%q is bound to both %s and %s", name, other, action)
+			}
+			bound[s] = action
+			bindings[action] = append(bindings[action], input)
+		}
+	}
+
+	for action, inputs := range defaultBindings {
+		for _, in := range inputs {
+			names := controller
+			switch in.device {
+			case inputKey:
+				names = keys
+			case inputMouseButton, inputMouseWheel:
+				names = mouse
+			}
+			if _, ok := names[action]; ok {
+				continue
+			}
+			s := slot{in, isFlyAction(action)}
+			if _, ok := bound[s]; ok {
+				continue
+			}
+			bound[s] = action
+			bindings[action] = append(bindings[action], in)
+		}
+	}
+	return bindings, nil
+}
+
+// bindingNames returns the names of the keys, mouse inputs and controller
+// inputs of bindings.
+func bindingNames(bindings map[Action][]Input) (keys, mouse, controller map[Action]string) {
+	keys = make(map[Action]string)
+	mouse = make(map[Action]string)
+	controller = make(map[Action]string)
+	for action, inputs := range bindings {
+		for _, in := range inputs {
+			switch in.device {
+			case inputKey:
+				keys[action] = in.name()
+			case inputMouseButton, inputMouseWheel:
+				mouse[action] = in.name()
+			default:
+				controller[action] = in.name()
+			}
+		}
+	}
+	return keys, mouse, controller
+}
+
+// setBindings sets the inputs of the actions.
+func (e *Engine) setBindings(bindings map[Action][]Input) {
+	e.bindings = bindings
+	e.inputActions = make(map[Input]Action)
+	e.flyInputs = make(map[Input]Action)
+	for action, inputs := range bindings {
+		for _, in := range inputs {
+			if isFlyAction(action) {
+				e.flyInputs[in] = action
+			} else {
+				e.inputActions[in] = action
+			}
+		}
+	}
+}
+
+// boundInput returns the input of an action on a device.
+func (e *Engine) boundInput(action Action, devices ...inputDevice) (Input, bool) {
+	for _, in := range e.bindings[action] {
+		for _, device := range devices {
+			if in.device == device {
+				return in, true
+			}
+		}
+	}
+	return Input{}, false
+}
+
+// keyLabel returns the help text for an action with its key, like
+// "[T] Texture".
+func (e *Engine) keyLabel(action Action, text string) string {
+	if in, ok := e.boundInput(action, inputKey); ok {
+		return "[" + in.name() + "] " + text
+	}
+	return text
+}
+
+// keysLabel returns the help text for actions with their keys, like
+// "[W/A/S/D] Move". Actions without a key are left out.
+func (e *Engine) keysLabel(text string, actions ...Action) string {
+	names := []string{}
+	for _, action := range actions {
+		if in, ok := e.boundInput(action, inputKey); ok {
+			names = append(names, in.name())
+		}
+	}
+	if len(names) == 0 {
+		return text
+	}
+	return "[" + strings.Join(names, "/") + "] " + text
+}
+
+// held returns true while an input of an action is held down.
+func (e *Engine) held(action Action) bool {
+	keys := sdl.GetKeyboardState()
+	for _, in := range e.bindings[action] {
+		switch in.device {
+		case inputKey:
+			if code := int(sdl.GetScancodeFromKey(sdl.Keycode(in.code))); code < len(keys) && keys[code] != 0 {
+				return true
+			}
+		case inputMouseButton:
+			if e.mouseButtons[uint8(in.code)] {
+				return true
+			}
+		case inputControllerButton:
+			for _, controller := range e.controllers {
+				if controller.Button(sdl.GameControllerButton(in.code)) != 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// pressInput does the action bound to a pressed key or button. In fly mode the
+// inputs of the fly actions only move. See updateFly.
+func (e *Engine) pressInput(in Input) {
+	if _, ok := e.flyInputs[in]; ok && e.flyMode {
+		return
+	}
+	if action, ok := e.inputActions[in]; ok {
+		e.doAction(action)
+	}
+}
+
+// pressButton does the action of a mouse or controller button. Keys go to the
+// map picker and the image viewer first, but they have no buttons. Instead no
+// buttons work while the picker is open, and in the image viewer only the
+// buttons that close it or quit work.
+func (e *Engine) pressButton(in Input) {
+	action, ok := e.inputActions[in]
+	if !ok || e.pickerOpen {
+		return
+	}
+	if e.imageMode && action != ActionImages && action != ActionQuit {
+		return
+	}
+	e.pressInput(in)
+}
+
+// handleActionEvent handles the mouse and controller events of the actions.
+func (e *Engine) handleActionEvent(event sdl.Event) {
+	switch t := event.(type) {
+	case *sdl.MouseButtonEvent:
+		down := t.Type == sdl.MOUSEBUTTONDOWN
+		e.mouseButtons[t.Button] = down
+		if down {
+			e.pressButton(mouseButtonInput(t.Button))
+		}
+	case *sdl.MouseMotionEvent:
+		if in, ok := e.boundInput(ActionOrbit, inputMouseButton); ok && e.mouseButtons[uint8(in.code)] {
+			e.orbit(float64(t.XRel), float64(t.YRel))
+		}
+		if in, ok := e.boundInput(ActionPan, inputMouseButton); ok && e.mouseButtons[uint8(in.code)] {
+			e.camera.Pan(float64(t.XRel), float64(t.YRel))
+		}
+	case *sdl.MouseWheelEvent:
+		if _, ok := e.boundInput(ActionZoom, inputMouseWheel); ok {
+			e.camera.AdjustZoom(float64(t.PreciseY))
+		}
+	case *sdl.ControllerDeviceEvent:
+		e.updateControllers(t)
+	case *sdl.ControllerButtonEvent:
+		if t.Type == sdl.CONTROLLERBUTTONDOWN {
+			e.pressButton(controllerButtonInput(sdl.GameControllerButton(t.Button)))
+		}
+	}
+}
+
+// updateControllers opens controllers when they are connected and closes them
+// when they are disconnected. Controllers that are connected at start are
+// reported as connected too.
+func (e *Engine) updateControllers(event *sdl.ControllerDeviceEvent) {
+	switch event.Type {
+	case sdl.CONTROLLERDEVICEADDED:
+		// Which is the device index when a controller is added.
+		if controller := sdl.GameControllerOpen(int(event.Which)); controller != nil {
+			e.controllers[controller.Joystick().InstanceID()] = controller
+		}
+	case sdl.CONTROLLERDEVICEREMOVED:
+		// Which is the instance id when a controller is removed.
+		if controller, ok := e.controllers[event.Which]; ok {
+			controller.Close()
+			delete(e.controllers, event.Which)
+		}
+	}
+}
+
+// controllerAxis returns an axis of a controller from -1 to 1, with the
+// deadzone removed.
+func controllerAxis(controller *sdl.GameController, axis sdl.GameControllerAxis) float64 {
+	value := float64(controller.Axis(axis))
+	if math.Abs(value) < controllerDeadzone {
+		return 0
+	}
+	sign := math.Copysign(1, value)
+	return sign * (math.Abs(value) - controllerDeadzone) / (32767 - controllerDeadzone)
+}
+
+// controllerStick returns the x and y axes of a controller stick from -1 to 1.
+func controllerStick(controller *sdl.GameController, stick int) (float64, float64) {
+	axisX, axisY := stickAxes(stick)
+	return controllerAxis(controller, axisX), controllerAxis(controller, axisY)
+}
+
+// stickAxes returns the x and y axes of a controller stick.
+func stickAxes(stick int) (sdl.GameControllerAxis, sdl.GameControllerAxis) {
+	if stick == stickRight {
+		return sdl.CONTROLLER_AXIS_RIGHTX, sdl.CONTROLLER_AXIS_RIGHTY
+	}
+	return sdl.CONTROLLER_AXIS_LEFTX, sdl.CONTROLLER_AXIS_LEFTY
+}
+
+// orbit moves the camera around the map, or looks around in fly mode. The game
+// camera doesn't orbit.
+func (e *Engine) orbit(xrel, yrel float64) {
+	if e.gameMode {
+		return
+	}
+	if e.flyMode {
+		e.camera.Look(xrel, yrel, e.delta)
+	} else {
+		e.camera.ProcessMouseMovement(xrel, yrel, e.delta)
+	}
+}
+
+// updateAnalog does the analog actions of the controller sticks and axes. In
+// fly mode the stick of FlyMove only moves. See updateFly.
+func (e *Engine) updateAnalog() {
+	if e.pickerOpen || e.imageMode {
+		// The map is hidden or behind the picker.
+		return
+	}
+	flyStick := func(in Input) bool {
+		fly, ok := e.boundInput(ActionFlyMove, inputControllerStick)
+		if !ok || !e.flyMode {
+			return false
+		}
+		if in.device == inputControllerStick {
+			return in.code == fly.code
+		}
+		axisX, axisY := stickAxes(fly.code)
+		return in.code == int(axisX) || in.code == int(axisY)
+	}
+	for _, controller := range e.controllers {
+		if in, ok := e.boundInput(ActionOrbit, inputControllerStick); ok && !flyStick(in) {
+			if x, y := controllerStick(controller, in.code); x != 0 || y != 0 {
+				e.orbit(x*stickOrbitSpeed, y*stickOrbitSpeed)
+			}
+		}
+		if in, ok := e.boundInput(ActionPan, inputControllerStick); ok && !flyStick(in) {
+			if x, y := controllerStick(controller, in.code); x != 0 || y != 0 {
+				e.camera.Pan(x*stickPanSpeed*e.delta, y*stickPanSpeed*e.delta)
+			}
+		}
+		if in, ok := e.boundInput(ActionZoom, inputControllerAxis); ok && !flyStick(in) {
+			// Pushing the stick up zooms in, like scrolling up.
+			if v := controllerAxis(controller, sdl.GameControllerAxis(in.code)); v != 0 {
+				e.camera.AdjustZoom(-v * stickZoomSpeed * e.delta)
+			}
+		}
+	}
+}
+
+func (e *Engine) doAction(action Action) {
+	switch action {
+	case ActionQuit:
+		e.isRunning = false
+	case ActionToggleHelp:
+		e.showHelp = !e.showHelp
+	case ActionToggleProjection:
+		// The game camera is always orthographic, so leave it.
+		e.gameMode = false
+		e.camera.toggleProjection()
+	case ActionToggleTexture:
+		e.options.showTexture = !e.options.showTexture
+	case ActionToggleFilter:
+		e.options.bilinearFilter = !e.options.bilinearFilter
+	case ActionCycleSupersampling:
+		e.cycleSupersampling()
+	case ActionToggleLighting:
+		e.options.showLighting = !e.options.showLighting
+	case ActionToggleGouraud:
+		e.options.smoothShading = !e.options.smoothShading
+	case ActionToggleWireframe:
+		e.options.showWireframe = !e.options.showWireframe
+	case ActionToggleBackground:
+		e.toggleBackgorund()
+	case ActionToggleAutorotate:
+		e.autorotate = !e.autorotate
+	case ActionPrevMap:
+		e.prevMap()
+	case ActionNextMap:
+		e.nextMap()
+	case ActionNextScenario:
+		e.nextScenario()
+	case ActionPlaceProps:
+		e.propMode = !e.propMode
+	case ActionImages:
+		e.toggleImageMode()
+	case ActionFindMap:
+		e.openPicker()
+	case ActionBrowseMaps:
+		e.openBrowser()
+	case ActionToggleFly:
+		e.toggleFly()
+	case ActionToggleGameCamera:
+		e.toggleGameCamera()
+	case ActionTurnLeft:
+		e.turnGameCamera(-1)
+	case ActionTurnRight:
+		e.turnGameCamera(1)
+	case ActionToggleTilt:
+		e.toggleGameTilt()
+	case ActionFitView:
+		e.fitMap()
+	}
+}
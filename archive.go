@@ -0,0 +1,180 @@
+// This file contains opening disc images that are stored compressed or
+// described by a cue sheet.
+//
+// The Reader needs random access to the image. A zip entry stored without
+// compression is read in place. Compressed zip entries and gzip files can only
+// be read from the start, so they are decompressed into a temporary file. A
+// cue sheet is resolved to the bin file of its first track, which may itself
+// be compressed.
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// openImage opens a disc image for random access by its file extension.
+func openImage(filename string) (io.ReaderAt, io.Closer, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".zip":
+		return openZip(filename)
+	case ".gz":
+		return openGzip(filename)
+	case ".cue":
+		bin, err := cueBinFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		return openImage(bin)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f, nil
+}
+
+// openZip opens the largest .bin or .iso entry of a zip file.
+func openZip(filename string) (io.ReaderAt, io.Closer, error) {
+	z, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entry *zip.File
+	for _, f := range z.File {
+		ext := strings.ToLower(filepath.Ext(f.Name))
+		if ext != ".bin" && ext != ".iso" {
+			continue
+		}
+		if entry == nil || f.UncompressedSize64 > entry.UncompressedSize64 {
+			entry = f
+		}
+	}
+	if entry == nil {
+		z.Close()
+		return nil, nil, fmt.Errorf("%s: no .bin or .iso file in zip", filename)
+	}
+
+	if entry.Method == zip.Store {
+		offset, err := entry.DataOffset()
+		if err != nil {
+			z.Close()
+			return nil, nil, err
+		}
+		// The zip reader doesn't expose its file, so open it again to read
+		// the entry in place.
+		f, err := os.Open(filename)
+		if err != nil {
+			z.Close()
+			return nil, nil, err
+		}
+		z.Close()
+		return io.NewSectionReader(f, offset, int64(entry.UncompressedSize64)), f, nil
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		z.Close()
+		return nil, nil, err
+	}
+	defer z.Close()
+	defer rc.Close()
+	return decompressToTemp(rc)
+}
+
+// openGzip decompresses a gzip file.
+func openGzip(filename string) (io.ReaderAt, io.Closer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	defer gz.Close()
+	return decompressToTemp(gz)
+}
+
+// tempFile is a temporary file that is removed when it is closed.
+//
+// Where the system allows it, the file is removed as soon as it is created and
+// only the open file keeps it alive. It is then freed even if the program
+// exits without closing it.
+type tempFile struct {
+	*os.File
+}
+
+func (t tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name()) // Already removed, except on Windows.
+	return err
+}
+
+// decompressToTemp copies a stream into a temporary file. Disc images are
+// several hundred megabytes, too much to keep in memory.
+func decompressToTemp(src io.Reader) (io.ReaderAt, io.Closer, error) {
+	f, err := os.CreateTemp("", "heretic-*.bin")
+	if err != nil {
+		return nil, nil, err
+	}
+	// Windows can't remove open files. There it is removed by Close.
+	os.Remove(f.Name())
+	t := tempFile{f}
+	if _, err := io.Copy(f, src); err != nil {
+		t.Close()
+		return nil, nil, err
+	}
+	return t, t, nil
+}
+
+// cueBinFile returns the path of the first file referenced by a cue sheet,
+// relative to the cue sheet. A cue sheet that references another cue sheet is
+// an error, so a cue sheet can't reference itself.
+//
+//	FILE "fft.bin" BINARY
+func cueBinFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(strings.ToUpper(line), "FILE ") {
+			continue
+		}
+		name := strings.TrimSpace(line[len("FILE "):])
+		if strings.HasPrefix(name, `"`) {
+			if end := strings.Index(name[1:], `"`); end >= 0 {
+				name = name[1 : end+1]
+			}
+		} else if fields := strings.Fields(name); len(fields) > 0 {
+			name = fields[0]
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(filename), name)
+		}
+		if strings.EqualFold(filepath.Ext(name), ".cue") {
+			return "", fmt.Errorf("%s: FILE is a cue sheet", filename)
+		}
+		return name, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New(filename + ": no FILE in cue sheet")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCueBinFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		sheet, want string
+	}{
+		{"FILE \"my game.bin\" BINARY\r\n  TRACK 01 MODE2/2352\r\n", filepath.Join(dir, "my game.bin")},
+		{"REM comment\nfile fft.bin BINARY\n", filepath.Join(dir, "fft.bin")},
+		{"FILE \"/discs/fft.bin\" BINARY\n", "/discs/fft.bin"},
+		{"TRACK 01 MODE2/2352\n", ""},
+		{"FILE \"game.cue\" BINARY\n", ""},
+		{"FILE other.CUE BINARY\n", ""},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(dir, "game.cue")
+		if err := os.WriteFile(filename, []byte(test.sheet), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := cueBinFile(filename)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q: got %q, want an error", test.sheet, got)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("%q: got %q, %v, want %q", test.sheet, got, err, test.want)
+		}
+	}
+}
+
+func TestOpenZip(t *testing.T) {
+	contents := []byte("heretic")
+	image := testImage(contents)
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		filename := filepath.Join(t.TempDir(), "fft.zip")
+		var buf bytes.Buffer
+		z := zip.NewWriter(&buf)
+		for _, file := range []struct {
+			name string
+			data []byte
+		}{{"readme.txt", []byte("readme")}, {"disc/FFT.ISO", image}} {
+			w, err := z.CreateHeader(&zip.FileHeader{Name: file.name, Method: method})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(file.data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewReader(filename)
+		if err != nil {
+			t.Fatalf("method %d: %v", method, err)
+		}
+		entry, err := r.findFile("DATA/TEST.BIN")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := r.readFile(entry.sector, entry.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, contents) {
+			t.Errorf("method %d: got %q, want %q", method, data, contents)
+		}
+		r.Close()
+	}
+}
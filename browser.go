@@ -0,0 +1,218 @@
+// This file contains the map browser.
+//
+// The browser is an overlay with a grid of map thumbnails. The thumbnails are
+// rendered with the software renderer into memory at a low resolution, one per
+// frame so the browser stays responsive while they are being made. They are
+// kept for the rest of the session.
+//
+// Maps that don't exist on the disc (MAP120-124 on the PSX) are not listed.
+package main
+
+import (
+	"fmt"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	browserColumns = 4
+	browserRows    = 3
+	browserTop     = 40 // Space for the title above the grid.
+	browserLabel   = 25 // Space for the name below each thumbnail.
+
+	// Longest name that fits below a thumbnail.
+	browserLabelLen = 30
+
+	thumbnailWidth  = 160
+	thumbnailHeight = 120
+)
+
+// renderThumbnail renders a mesh from the default camera angle into a texture.
+func renderThumbnail(mesh Mesh, width, height int) Texture {
+	return renderMesh(mesh, width, height, Orthographic, defaultEyeOffset, DefaultRenderOptions(), 1)
+}
+
+func (e *Engine) openBrowser() {
+	if e.browserMaps == nil {
+		e.browserMaps = []int{}
+		for n := firstMap; n <= lastMap; n++ {
+			if e.reader.HasMap(n) {
+				e.browserMaps = append(e.browserMaps, n)
+			}
+		}
+		e.thumbnails = make(map[int]Texture)
+	}
+
+	e.browserOpen = true
+	for i, n := range e.browserMaps {
+		if n == e.currentMap {
+			e.selectBrowser(i)
+		}
+	}
+}
+
+// selectBrowser selects a map by index and scrolls it into view.
+func (e *Engine) selectBrowser(index int) {
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(e.browserMaps) {
+		index = len(e.browserMaps) - 1
+	}
+	e.browserIndex = index
+
+	row := index / browserColumns
+	if row < e.browserScroll {
+		e.browserScroll = row
+	}
+	if row >= e.browserScroll+browserRows {
+		e.browserScroll = row - browserRows + 1
+	}
+}
+
+// scrollBrowser scrolls by rows, keeping the selection in view.
+func (e *Engine) scrollBrowser(rows int) {
+	maxScroll := (len(e.browserMaps)-1)/browserColumns - browserRows + 1
+	if maxScroll < 0 {
+		maxScroll = 0
+	}
+	e.browserScroll = int(clamp(float64(e.browserScroll+rows), 0, float64(maxScroll)))
+
+	row := e.browserIndex / browserColumns
+	if row < e.browserScroll {
+		e.browserIndex += (e.browserScroll - row) * browserColumns
+	}
+	if row >= e.browserScroll+browserRows {
+		e.browserIndex -= (row - e.browserScroll - browserRows + 1) * browserColumns
+	}
+}
+
+func (e *Engine) loadBrowserMap() {
+	e.browserOpen = false
+	e.setMap(e.browserMaps[e.browserIndex], 0)
+}
+
+// browserCell returns the size of a grid cell in window pixels.
+func (e *Engine) browserCell() (int, int) {
+	return e.window.width / browserColumns, (e.window.height - browserTop) / browserRows
+}
+
+// browserIndexAt returns the index of the map under a window position.
+func (e *Engine) browserIndexAt(x, y int) (int, bool) {
+	w, h := e.browserCell()
+	if y < browserTop {
+		return 0, false
+	}
+	column, row := x/w, (y-browserTop)/h
+	if column >= browserColumns || row >= browserRows {
+		return 0, false
+	}
+	index := (e.browserScroll+row)*browserColumns + column
+	return index, index < len(e.browserMaps)
+}
+
+// handleBrowserEvent handles all input while the browser is open. A click
+// selects a map and a double click loads it.
+func (e *Engine) handleBrowserEvent(event sdl.Event) {
+	switch t := event.(type) {
+	case *sdl.KeyboardEvent:
+		if t.Type == sdl.KEYDOWN {
+			e.handleBrowserKey(t.Keysym.Sym)
+		}
+	case *sdl.MouseButtonEvent:
+		if t.Type != sdl.MOUSEBUTTONDOWN || t.Button != sdl.BUTTON_LEFT {
+			return
+		}
+		if index, ok := e.browserIndexAt(int(t.X), int(t.Y)); ok {
+			e.browserIndex = index
+			if t.Clicks >= 2 {
+				e.loadBrowserMap()
+			}
+		}
+	case *sdl.MouseWheelEvent:
+		if t.Y > 0 {
+			e.scrollBrowser(-1)
+		} else if t.Y < 0 {
+			e.scrollBrowser(1)
+		}
+	}
+}
+
+// handleBrowserKey handles keys in the map browser. Escape and the keys bound
+// to BrowseMaps close it.
+func (e *Engine) handleBrowserKey(key sdl.Keycode) {
+	if action, ok := e.inputActions[keyInput(key)]; key == sdl.K_ESCAPE || ok && action == ActionBrowseMaps {
+		e.browserOpen = false
+		return
+	}
+	switch key {
+	case sdl.K_RETURN:
+		e.loadBrowserMap()
+	case sdl.K_LEFT:
+		e.selectBrowser(e.browserIndex - 1)
+	case sdl.K_RIGHT:
+		e.selectBrowser(e.browserIndex + 1)
+	case sdl.K_UP:
+		e.selectBrowser(e.browserIndex - browserColumns)
+	case sdl.K_DOWN:
+		e.selectBrowser(e.browserIndex + browserColumns)
+	case sdl.K_PAGEUP:
+		e.selectBrowser(e.browserIndex - browserColumns*browserRows)
+	case sdl.K_PAGEDOWN:
+		e.selectBrowser(e.browserIndex + browserColumns*browserRows)
+	}
+}
+
+// updateBrowser renders the first missing thumbnail that is in view. Maps that
+// aren't cached are loaded in the background first.
+func (e *Engine) updateBrowser() {
+	first := e.browserScroll * browserColumns
+	for i := first; i < first+browserColumns*browserRows && i < len(e.browserMaps); i++ {
+		n := e.browserMaps[i]
+		if _, ok := e.thumbnails[n]; ok {
+			continue
+		}
+		if mesh, ok := e.loader.cached(n, 0); ok {
+			e.thumbnails[n] = renderThumbnail(mesh, thumbnailWidth, thumbnailHeight)
+		} else if e.loader.err(n, 0) != nil {
+			// Leave the thumbnail empty.
+			e.thumbnails[n] = renderThumbnail(NewMesh(), thumbnailWidth, thumbnailHeight)
+		} else {
+			e.loader.load(n, 0)
+		}
+		return
+	}
+}
+
+// renderBrowser draws the grid of thumbnails instead of the map.
+func (e *Engine) renderBrowser() {
+	ssaa := e.window.ssaa
+	w, h := e.browserCell()
+
+	e.renderer.DrawRect(0, 0, e.window.bufferWidth, e.window.bufferHeight, Color{0, 0, 0, 220})
+	e.window.SetText(10, 10, "[Arrows] Select [Enter/Double click] Load [Wheel] Scroll [Esc] Close", White)
+
+	first := e.browserScroll * browserColumns
+	for i := first; i < first+browserColumns*browserRows && i < len(e.browserMaps); i++ {
+		n := e.browserMaps[i]
+		x := ((i - first) % browserColumns) * w
+		y := browserTop + ((i-first)/browserColumns)*h
+
+		color := DarkGray
+		if i == e.browserIndex {
+			color = Yellow
+		}
+		e.renderer.DrawRect((x+4)*ssaa, (y+4)*ssaa, (w-8)*ssaa, (h-8)*ssaa, color)
+		e.renderer.DrawRect((x+6)*ssaa, (y+6)*ssaa, (w-12)*ssaa, (h-12)*ssaa, Black)
+
+		if thumbnail, ok := e.thumbnails[n]; ok {
+			e.renderer.DrawImageRect(thumbnail, (x+6)*ssaa, (y+6)*ssaa, (w-12)*ssaa, (h-12-browserLabel)*ssaa)
+		}
+		label := fmt.Sprintf("%d %s", n, mapNames[n].name)
+		if len(label) > browserLabelLen {
+			label = label[:browserLabelLen-3] + "..."
+		}
+		e.window.SetText(x+10, y+h-browserLabel-4, label, White)
+	}
+	e.window.Present()
+}
@@ -7,8 +7,30 @@ import (
 const (
 	MinZoom = 2.0
 	MaxZoom = 0.5
+
+	// cameraDamping is how quickly the camera stops after an input, in
+	// seconds. The remaining motion drops to about a third in this time.
+	cameraDamping = 0.08
+
+	// cameraTransitionDuration is how long MoveTo takes, in seconds.
+	cameraTransitionDuration = 0.4
+
+	// fitMargin is the space left around a map fit in the view, as a
+	// fraction of its size.
+	fitMargin = 1.05
+
+	// Remaining motion below this is applied at once.
+	motionEpsilon = 1e-5
+
+	// maxFov limits the perspective field of view, in radians. fitZoomLimits
+	// can allow a zoom past MinZoom, which would open it to 180 degrees.
+	maxFov = 2 * math.Pi / 3
 )
 
+// defaultEyeOffset is the offset of the eye from the center of a map for the
+// default camera angle.
+var defaultEyeOffset = Vec3{1, 1, -1}
+
 type Projection int
 
 const (
@@ -28,6 +50,28 @@ type Camera struct {
 	width  int
 	height int
 	zoom   float64
+
+	// Zoom limits. They are MaxZoom and MinZoom, widened by fitZoomLimits
+	// for maps that fit the view at a smaller or larger zoom.
+	zoomIn, zoomOut float64
+
+	// Motion of the inputs that hasn't been applied yet. Update applies part
+	// of it every frame, so the camera slows down smoothly when the input
+	// stops instead of stopping at once.
+	orbitTheta, orbitPhi float64 // Radians
+	panX, panY           float64 // Pixels
+	zoomSteps            float64
+
+	transition *cameraTransition
+}
+
+// cameraTransition is an eased move of the camera. The offset of the eye from
+// front is interpolated instead of the eye, so the camera keeps looking in
+// the same direction while front moves.
+type cameraTransition struct {
+	fromFront, fromOffset, toFront, toOffset Vec3
+	fromZoom, toZoom                         float64
+	progress                                 float64 // 0 to 1
 }
 
 func NewCamera(eye, front, up Vec3, width, height int) *Camera {
@@ -39,6 +83,8 @@ func NewCamera(eye, front, up Vec3, width, height int) *Camera {
 		height:     height,
 		projection: Orthographic,
 		zoom:       1.0,
+		zoomIn:     MaxZoom,
+		zoomOut:    MinZoom,
 	}
 	c.updateProjectionMatrix()
 	c.updateViewMatrix()
@@ -53,18 +99,27 @@ func (c *Camera) updateViewMatrix() {
 	c.viewMatrix = LookAt(c.eye, c.front, c.up)
 }
 
+// AdjustZoom zooms by a number of mouse wheel steps.
 func (c *Camera) AdjustZoom(f float64) {
+	c.zoomSteps += f
+}
+
+func (c *Camera) zoomBy(f float64) {
 	f *= 0.1
-	c.zoom -= f
+	c.zoom = c.clampZoom(c.zoom - f)
+	c.updateProjectionMatrix()
+}
 
-	if c.zoom < MaxZoom {
-		c.zoom = MaxZoom
-	}
-	if c.zoom > MinZoom {
-		c.zoom = MinZoom
-	}
+func (c *Camera) clampZoom(zoom float64) float64 {
+	return clamp(zoom, c.zoomIn, c.zoomOut)
+}
 
-	c.updateProjectionMatrix()
+// fitZoomLimits sets the zoom limits for a map that fits the view at a zoom.
+// The map can be zoomed as far in and out from its fit as a map that fits at
+// zoom 1, and at least as far as MaxZoom and MinZoom.
+func (c *Camera) fitZoomLimits(zoom float64) {
+	c.zoomIn = math.Min(MaxZoom, zoom*MaxZoom)
+	c.zoomOut = math.Max(MinZoom, zoom*MinZoom)
 }
 
 func (c *Camera) toggleProjection() {
@@ -84,13 +139,23 @@ func (c *Camera) updateProjectionMatrix() {
 		h := 1.0 * aspect * c.zoom
 		c.projectionMatrix = MatrixOrtho(-w, w, -h, h, 1.0, 100.0)
 	} else {
-		// (180/3 = 60 degrees). Value is in radians.
-		fov := (math.Pi / 3.0) * c.zoom
-		c.projectionMatrix = MatrixPerspective(fov, aspect, 1.0, 100.0)
+		c.projectionMatrix = MatrixPerspective(c.fov(), aspect, 1.0, 100.0)
 	}
 }
 
+// fov returns the vertical field of view of the perspective projection, in
+// radians. It is 60 degrees at zoom 1.
+func (c *Camera) fov() float64 {
+	return math.Min((math.Pi/3.0)*c.zoom, maxFov)
+}
+
+// ProcessMouseMovement orbits the eye around front by a mouse movement.
 func (c *Camera) ProcessMouseMovement(xrel, yrel, delta float64) {
+	c.orbitTheta += xrel * delta / 4
+	c.orbitPhi += -yrel * delta / 4
+}
+
+func (c *Camera) orbit(dtheta, dphi float64) {
 	const EPS = 0.0001
 
 	minPolarAngle := 0.0
@@ -103,8 +168,8 @@ func (c *Camera) ProcessMouseMovement(xrel, yrel, delta float64) {
 
 	// Calculate angles based on current camera position plus deltas
 	radius := tcam.Length()
-	theta := math.Atan2(tcam.x, tcam.z) + (xrel * delta / 4)
-	phi := math.Acos(tcam.y/radius) + (-yrel * delta / 4)
+	theta := math.Atan2(tcam.x, tcam.z) + dtheta
+	phi := math.Acos(tcam.y/radius) + dphi
 
 	// Restrict phi and theta to be between desired limits
 	phi = clamp(phi, minPolarAngle, maxPolarAngle)
@@ -124,3 +189,199 @@ func (c *Camera) ProcessMouseMovement(xrel, yrel, delta float64) {
 
 	c.updateViewMatrix()
 }
+
+// forward returns the direction the camera is looking.
+func (c *Camera) forward() Vec3 {
+	return c.front.Sub(c.eye).Normalize()
+}
+
+// right returns the direction to the right of the screen. See LookAt.
+func (c *Camera) right() Vec3 {
+	return c.up.Cross(c.forward()).Normalize()
+}
+
+// Move moves the eye and front together.
+func (c *Camera) Move(offset Vec3) {
+	c.transition = nil
+	c.eye = c.eye.Add(offset)
+	c.front = c.front.Add(offset)
+	c.updateViewMatrix()
+}
+
+// Pan moves the camera in the view plane so the map follows the mouse. The
+// movement is in window pixels.
+func (c *Camera) Pan(xrel, yrel float64) {
+	c.panX += xrel
+	c.panY += yrel
+}
+
+func (c *Camera) pan(xrel, yrel float64) {
+	// World units per pixel at the distance of front.
+	var scale float64
+	if c.projection == Orthographic {
+		scale = 2 * c.zoom / float64(c.width)
+	} else {
+		scale = 2 * c.front.Sub(c.eye).Length() * math.Tan(c.fov()/2) / float64(c.height)
+	}
+
+	right := c.right()
+	up := c.forward().Cross(right)
+	c.Move(right.Mul(-xrel * scale).Add(up.Mul(yrel * scale)))
+}
+
+// Look turns the camera around the eye, like looking around in a first person
+// game. It is the opposite of ProcessMouseMovement which moves the eye around
+// front.
+func (c *Camera) Look(xrel, yrel, delta float64) {
+	const EPS = 0.0001
+
+	c.transition = nil
+
+	dir := c.front.Sub(c.eye)
+	radius := dir.Length()
+	theta := math.Atan2(dir.x, dir.z) + (xrel * delta / 4)
+	phi := math.Acos(dir.y/radius) + (yrel * delta / 4)
+	phi = clamp(phi, EPS, math.Pi-EPS)
+
+	dir.x = radius * math.Sin(phi) * math.Sin(theta)
+	dir.y = radius * math.Cos(phi)
+	dir.z = radius * math.Sin(phi) * math.Cos(theta)
+
+	c.front = c.eye.Add(dir)
+	c.updateViewMatrix()
+}
+
+// Angles returns the azimuth and elevation of the eye around front in
+// radians. The azimuth is around the y axis from +z and the elevation is up
+// from the xz plane.
+func (c *Camera) Angles() (azimuth, elevation float64) {
+	tcam := c.eye.Sub(c.front)
+	return math.Atan2(tcam.x, tcam.z), math.Asin(tcam.y / tcam.Length())
+}
+
+// SetAngles moves the eye around front to an azimuth and elevation, keeping
+// its distance. See Angles.
+func (c *Camera) SetAngles(azimuth, elevation float64) {
+	c.eye = c.front.Add(orbitOffset(azimuth, elevation, c.eye.Sub(c.front).Length()))
+	c.updateViewMatrix()
+}
+
+// orbitOffset returns the offset of an eye from its front at an azimuth,
+// elevation and distance. See Angles.
+func orbitOffset(azimuth, elevation, distance float64) Vec3 {
+	return Vec3{
+		distance * math.Cos(elevation) * math.Sin(azimuth),
+		distance * math.Sin(elevation),
+		distance * math.Cos(elevation) * math.Cos(azimuth),
+	}
+}
+
+// MoveTo moves the camera to an eye, front and zoom. The move is animated by
+// Update.
+func (c *Camera) MoveTo(eye, front Vec3, zoom float64) {
+	c.Stop()
+	c.transition = &cameraTransition{
+		fromFront:  c.front,
+		fromOffset: c.eye.Sub(c.front),
+		fromZoom:   c.zoom,
+		toFront:    front,
+		toOffset:   eye.Sub(front),
+		toZoom:     c.clampZoom(zoom),
+	}
+}
+
+// Stop drops the remaining motion and transition.
+func (c *Camera) Stop() {
+	c.orbitTheta, c.orbitPhi = 0, 0
+	c.panX, c.panY = 0, 0
+	c.zoomSteps = 0
+	c.transition = nil
+}
+
+// Update moves the camera by part of the remaining motion, or along the
+// transition. An input during a transition stops it.
+func (c *Camera) Update(delta float64) {
+	moving := c.orbitTheta != 0 || c.orbitPhi != 0 || c.panX != 0 || c.panY != 0 || c.zoomSteps != 0
+	if c.transition != nil && !moving {
+		c.updateTransition(delta)
+		return
+	}
+	c.transition = nil
+
+	// The same fraction of the remaining motion is applied in the same time,
+	// at any frame rate.
+	k := 1 - math.Exp(-delta/cameraDamping)
+	if dtheta, dphi := damp(&c.orbitTheta, k), damp(&c.orbitPhi, k); dtheta != 0 || dphi != 0 {
+		c.orbit(dtheta, dphi)
+	}
+	if dx, dy := damp(&c.panX, k), damp(&c.panY, k); dx != 0 || dy != 0 {
+		c.pan(dx, dy)
+	}
+	if dz := damp(&c.zoomSteps, k); dz != 0 {
+		c.zoomBy(dz)
+	}
+}
+
+// damp returns the fraction k of the remaining motion v and removes it from v.
+// Once little is left the rest is returned.
+func damp(v *float64, k float64) float64 {
+	d := *v * k
+	if math.Abs(*v) < motionEpsilon {
+		d = *v
+	}
+	*v -= d
+	return d
+}
+
+func (c *Camera) updateTransition(delta float64) {
+	t := c.transition
+	t.progress = math.Min(t.progress+delta/cameraTransitionDuration, 1)
+
+	// Ease in and out.
+	f := t.progress * t.progress * (3 - 2*t.progress)
+	c.front = t.fromFront.Add(t.toFront.Sub(t.fromFront).Mul(f))
+	c.eye = c.front.Add(t.fromOffset.Add(t.toOffset.Sub(t.fromOffset).Mul(f)))
+	c.zoom = t.fromZoom + (t.toZoom-t.fromZoom)*f
+	c.updateProjectionMatrix()
+	c.updateViewMatrix()
+
+	if t.progress == 1 {
+		c.transition = nil
+	}
+}
+
+// Fit returns the eye, front and zoom that fit the corners of a box and its
+// bounding sphere in the view, looking from the same direction. The box may be
+// rotated. Perspective moves the eye so the sphere fits the narrower field of
+// view. Orthographic changes the zoom so the corners fit, and keeps the
+// distance. The zoom isn't limited, so small maps fill the view too. See
+// fitZoomLimits.
+func (c *Camera) Fit(corners [8]Vec3, sphere Sphere) (eye, front Vec3, zoom float64) {
+	forward := c.forward()
+	for _, corner := range corners {
+		front = front.Add(corner)
+	}
+	front = front.Div(float64(len(corners)))
+	distance := c.front.Sub(c.eye).Length()
+	zoom = c.zoom
+
+	if c.projection == Orthographic {
+		// The view is zoom wide and aspect*zoom high on each side of front.
+		right := c.right()
+		up := forward.Cross(right)
+		var width, height float64
+		for _, corner := range corners {
+			p := corner.Sub(front)
+			width = math.Max(width, math.Abs(p.Dot(right)))
+			height = math.Max(height, math.Abs(p.Dot(up)))
+		}
+		zoom = math.Max(width, height/c.aspectRatio()) * fitMargin
+	} else {
+		// The field of view is vertical. See MatrixPerspective.
+		half := math.Atan(math.Tan(c.fov()/2) * math.Min(1, 1/c.aspectRatio()))
+		distance = sphere.radius * fitMargin / math.Sin(half)
+		front = sphere.center
+	}
+
+	return front.Sub(forward.Mul(distance)), front, zoom
+}
@@ -72,6 +72,20 @@ func (c Color) Scale(factor float64) Color {
 	}
 }
 
+// interpolateColor blends three colors with barycentric weights.
+func interpolateColor(a, b, c Color, wa, wb, wc float64) Color {
+	r := float64(a.R)*wa + float64(b.R)*wb + float64(c.R)*wc
+	g := float64(a.G)*wa + float64(b.G)*wb + float64(c.G)*wc
+	bl := float64(a.B)*wa + float64(b.B)*wb + float64(c.B)*wc
+	al := float64(a.A)*wa + float64(b.A)*wb + float64(c.A)*wc
+	return Color{
+		R: uint8(clamp(r, 0, 255)),
+		G: uint8(clamp(g, 0, 255)),
+		B: uint8(clamp(bl, 0, 255)),
+		A: uint8(clamp(al, 0, 255)),
+	}
+}
+
 func randColor() Color {
 	return Color{
 		R: uint8(rand.Intn(256)),
@@ -0,0 +1,238 @@
+// This file contains the config file of the viewer.
+//
+// The config file keeps the options, window size, key bindings and the last
+// viewed map between sessions. It is heretic/config.json in the user config
+// directory, like ~/.config/heretic/config.json on Linux. It is read when the
+// viewer starts and written when it exits. A file that can't be read is not
+// overwritten. Command line flags override it.
+//
+//	{
+//	  "options": {"texture": true, "lighting": true, "ssaa": 2, "projection": "perspective", "quadrant": -1, ...},
+//	  "window": {"width": 1280, "height": 960},
+//	  "keys": {"NextMap": "Right", "PrevMap": "Left", ...},
+//	  "mouse": {"Orbit": "left", "Pan": "right", "Zoom": "wheel"},
+//	  "controller": {"NextMap": "rightshoulder", "Orbit": "right", "Zoom": "lefty", ...},
+//	  "last": {"map": 49, "scenario": 0, "camera": {"eye": [1, 1, -1], "front": [0, 0, 0], "zoom": 1}}
+//	}
+//
+// Missing values keep their defaults. A default binding to an input that the
+// file binds to another action is dropped. The actions and input names are
+// listed in actions.go. An empty input name unbinds an action. A file with an
+// invalid value is treated like one that can't be read.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type Config struct {
+	Options    ConfigOptions     `json:"options"`
+	Window     ConfigWindow      `json:"window"`
+	Keys       map[Action]string `json:"keys"`
+	Mouse      map[Action]string `json:"mouse"`
+	Controller map[Action]string `json:"controller"`
+	Last       ConfigLast        `json:"last"`
+}
+
+type ConfigOptions struct {
+	Texture    bool   `json:"texture"`
+	Lighting   bool   `json:"lighting"`
+	Wireframe  bool   `json:"wireframe"`
+	Filter     bool   `json:"filter"`
+	Gouraud    bool   `json:"gouraud"`
+	SSAA       int    `json:"ssaa"`
+	Autorotate bool   `json:"autorotate"`
+	Background bool   `json:"background"`
+	Help       bool   `json:"help"`
+	Projection string `json:"projection"`
+	Quadrant   int    `json:"quadrant"`
+	Tilt       string `json:"tilt"`
+}
+
+type ConfigWindow struct {
+	Width      int  `json:"width"`
+	Height     int  `json:"height"`
+	Fullscreen bool `json:"fullscreen,omitempty"`
+}
+
+type ConfigLast struct {
+	Map      int           `json:"map"`
+	Scenario int           `json:"scenario"`
+	Camera   *ConfigCamera `json:"camera,omitempty"`
+}
+
+type ConfigCamera struct {
+	Eye   [3]float64 `json:"eye"`
+	Front [3]float64 `json:"front"`
+	Zoom  float64    `json:"zoom"`
+}
+
+func newConfigCamera(c *Camera) *ConfigCamera {
+	return &ConfigCamera{
+		Eye:   [3]float64{c.eye.x, c.eye.y, c.eye.z},
+		Front: [3]float64{c.front.x, c.front.y, c.front.z},
+		Zoom:  c.zoom,
+	}
+}
+
+func (cc ConfigCamera) apply(c *Camera) {
+	c.Stop()
+	c.eye = Vec3{cc.Eye[0], cc.Eye[1], cc.Eye[2]}
+	c.front = Vec3{cc.Front[0], cc.Front[1], cc.Front[2]}
+	c.zoom = c.clampZoom(cc.Zoom)
+	c.updateProjectionMatrix()
+	c.updateViewMatrix()
+}
+
+// newConfig returns the config for a view config and bindings.
+func newConfig(v viewConfig, bindings map[Action][]Input) Config {
+	keys, mouse, controller := bindingNames(bindings)
+	return Config{
+		Options: ConfigOptions{
+			Texture:    v.options.showTexture,
+			Lighting:   v.options.showLighting,
+			Wireframe:  v.options.showWireframe,
+			Filter:     v.options.bilinearFilter,
+			Gouraud:    v.options.smoothShading,
+			SSAA:       v.ssaa,
+			Autorotate: v.autorotate,
+			Background: v.background,
+			Help:       v.hud,
+			Projection: v.projection,
+			Quadrant:   v.quadrant,
+			Tilt:       v.tilt,
+		},
+		Window:     ConfigWindow{Width: v.width, Height: v.height, Fullscreen: v.fullscreen},
+		Keys:       keys,
+		Mouse:      mouse,
+		Controller: controller,
+		Last:       ConfigLast{Map: v.mapNum, Scenario: v.scenario},
+	}
+}
+
+// bindings returns the bindings of the config.
+func (c Config) bindings() (map[Action][]Input, error) {
+	return parseBindings(c.Keys, c.Mouse, c.Controller)
+}
+
+// check returns an error if a binding or an option of the config is invalid.
+// The last map is checked against the disc by viewConfig.check.
+func (c Config) check() error {
+	if _, err := c.bindings(); err != nil {
+		return err
+	}
+	v := c.view()
+	return v.checkOptions()
+}
+
+// view returns the view config with the values of the config.
+func (c Config) view() viewConfig {
+	return viewConfig{
+		mapNum:     c.Last.Map,
+		scenario:   c.Last.Scenario,
+		width:      c.Window.Width,
+		height:     c.Window.Height,
+		fullscreen: c.Window.Fullscreen,
+		projection: c.Options.Projection,
+		quadrant:   c.Options.Quadrant,
+		tilt:       c.Options.Tilt,
+		options: RenderOptions{
+			showTexture:    c.Options.Texture,
+			showLighting:   c.Options.Lighting,
+			showWireframe:  c.Options.Wireframe,
+			bilinearFilter: c.Options.Filter,
+			smoothShading:  c.Options.Gouraud,
+		},
+		ssaa:       c.Options.SSAA,
+		autorotate: c.Options.Autorotate,
+		background: c.Options.Background,
+		hud:        c.Options.Help,
+	}
+}
+
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "heretic", "config.json"), nil
+}
+
+// LoadConfig reads the config file. Without a config file the defaults are
+// returned.
+func LoadConfig() (Config, error) {
+	config := newConfig(defaultViewConfig(), defaultBindings)
+
+	path, err := configPath()
+	if err != nil {
+		return config, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+
+	// Only the bindings of the file are kept. The defaults are added by
+	// parseBindings, so actions added after the file was written are still
+	// bound, except to inputs the file uses.
+	config.Keys, config.Mouse, config.Controller = nil, nil, nil
+	if err := json.Unmarshal(data, &config); err != nil {
+		return newConfig(defaultViewConfig(), defaultBindings), fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// SaveConfig writes the config file.
+func SaveConfig(config Config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// config returns the config of the current session.
+func (e *Engine) config(fullscreen bool) Config {
+	v := viewConfig{
+		mapNum:     e.currentMap,
+		scenario:   e.currentScenario,
+		width:      e.window.width,
+		height:     e.window.height,
+		fullscreen: fullscreen,
+		projection: "orthographic",
+		quadrant:   -1,
+		tilt:       "low",
+		options:    e.options,
+		ssaa:       e.window.ssaa,
+		autorotate: e.autorotate,
+		background: e.showMapBackground,
+		hud:        e.showHelp,
+	}
+	if e.camera.projection == Perspective {
+		v.projection = "perspective"
+	}
+	if e.gameMode {
+		v.quadrant = e.game.quadrant
+	}
+	if e.game.high {
+		v.tilt = "high"
+	}
+	config := newConfig(v, e.bindings)
+	config.Last.Camera = newConfigCamera(e.camera)
+	return config
+}
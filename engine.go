@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"sort"
+	"os"
 	"unsafe"
 
 	"github.com/veandco/go-sdl2/sdl"
@@ -11,57 +11,138 @@ import (
 const (
 	FPS        = 60
 	MSPerFrame = (1000 / FPS)
+
+	maxSupersampling = 4
 )
 
-var (
+// modelScale is the scale applied to FFT maps. Their vertex coordinates are
+// very small after conversion from fixed-point.
+const modelScale float64 = 15.0
+
+type Engine struct {
+	window   *Window
+	renderer *Renderer
+	camera   *Camera
+
+	// Scene
+	scene             *Scene
+	mapNode           *Node
+	currentMap        int
+	currentScenario   int
+	scenarios         []Scenario // Of the current map.
+	loadingMap        int        // Map being loaded in the background, or 0.
+	loadingScenario   int
+	loader            *mapLoader
+	trianglesToRender []Triangle
+	renderedModels    int
+
 	// Options
-	autorotate        = false
-	showHelp          = true
-	showTexture       = true
-	showWireframe     = false
-	showMapBackground = false
-	showLighting      = true
+	options           RenderOptions
+	autorotate        bool
+	showHelp          bool
+	showMapBackground bool
 
 	// Timing
 	previous   uint32
 	delta      float64
 	frameCount int
 
-	// Controls
-	leftButtonDown = false
-
-	// Data
-	model      Model
-	currentMap int
-
-	modelScale float64 = 15.0
-)
-
-type Engine struct {
-	window   *Window
-	renderer *Renderer
-	camera   *Camera
+	// Controls. See actions.go.
+	bindings     map[Action][]Input
+	inputActions map[Input]Action
+	flyInputs    map[Input]Action // Only in fly mode
+	mouseButtons map[uint8]bool
+	controllers  map[sdl.JoystickID]*sdl.GameController
+
+	// Camera to restore when the first map is shown. See config.go.
+	restoreCamera *ConfigCamera
+
+	// Props. See props.go.
+	props      []PropPlacement
+	propNodes  []*Node
+	propMeshes map[string]Mesh
+	propObj    string
+	propMode   bool
+	tileCursor TilePosition
+
+	// Fly mode. See fly.go.
+	flyMode bool
+
+	// Game camera. See gamecamera.go.
+	gameMode bool
+	game     gameCamera
+
+	// Units. See sprite.go.
+	unitNodes    []*Node
+	spriteSheets map[string]SpriteSheet
+
+	// Image viewer. See images.go.
+	imageMode  bool
+	images     []string
+	imageIndex int
+	imageClut  int
+	image      TIM
+
+	// Map picker. See picker.go.
+	catalog       []MapInfo
+	catalogResult chan catalogResult // While the catalog is read.
+	pickerOpen    bool
+	pickerQuery   string
+	pickerResults []MapInfo
+	pickerIndex   int
+
+	// Map browser. See browser.go.
+	browserOpen   bool
+	browserMaps   []int
+	browserIndex  int
+	browserScroll int
+	thumbnails    map[int]Texture
 
 	reader    *Reader
 	isRunning bool
 }
 
 func NewEngine(window *Window, renderer *Renderer, reader *Reader) *Engine {
-	return &Engine{
+	scene := NewScene()
+	mapNode := scene.root.AddChild(NewNode("map", NewModel(NewMesh())))
+	e := &Engine{
 		window:   window,
 		renderer: renderer,
 		reader:   reader,
-		camera:   NewCamera(Vec3{1, 1, -1}, Vec3{0, 0, 0}, Vec3{0, 1, 0}, window.width, window.height),
+		loader:   newMapLoader(reader),
+		camera:   NewCamera(defaultEyeOffset, Vec3{0, 0, 0}, Vec3{0, 1, 0}, window.width, window.height),
+		scene:    scene,
+		mapNode:  mapNode,
+		options:  DefaultRenderOptions(),
+		showHelp: true,
+
+		propMeshes: make(map[string]Mesh),
+		propObj:    defaultPropObj,
+
+		spriteSheets: make(map[string]SpriteSheet),
+
+		mouseButtons: make(map[uint8]bool),
+		controllers:  make(map[sdl.JoystickID]*sdl.GameController),
 	}
+	e.setBindings(defaultBindings)
+	return e
 }
 
 func (e *Engine) setup() {
 	e.isRunning = true
-	previous = sdl.GetTicks()
+	e.previous = sdl.GetTicks()
 }
 
 func (e *Engine) processInput() {
 	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		switch event.(type) {
+		case *sdl.QuitEvent, *sdl.ControllerDeviceEvent:
+		default:
+			if e.browserOpen {
+				e.handleBrowserEvent(event)
+				continue
+			}
+		}
 		switch t := event.(type) {
 		case *sdl.QuitEvent:
 			e.isRunning = false
@@ -69,247 +150,333 @@ func (e *Engine) processInput() {
 			if t.Type != sdl.KEYDOWN {
 				continue
 			}
-			switch t.Keysym.Sym {
-			case sdl.K_ESCAPE:
-				e.isRunning = false
-			case sdl.K_a:
-				autorotate = !autorotate
-			case sdl.K_h:
-				showHelp = !showHelp
-			case sdl.K_t:
-				showTexture = !showTexture
-			case sdl.K_w:
-				showWireframe = !showWireframe
-			case sdl.K_l:
-				showLighting = !showLighting
-			case sdl.K_p:
-				e.camera.toggleProjection()
-			case sdl.K_b:
-				e.toggleBackgorund()
-			case sdl.K_j:
-				e.prevMap()
-			case sdl.K_k:
-				e.nextMap()
+			if e.pickerOpen {
+				e.handlePickerKey(t.Keysym.Sym)
+				continue
 			}
-		case *sdl.MouseButtonEvent:
-			if t.Button == sdl.BUTTON_LEFT {
-				leftButtonDown = t.Type == sdl.MOUSEBUTTONDOWN
+			if e.imageMode && e.handleImageKey(t.Keysym.Sym) {
+				continue
 			}
-		case *sdl.MouseMotionEvent:
-			if leftButtonDown {
-				e.camera.ProcessMouseMovement(float64(t.XRel), float64(t.YRel), delta)
+			if e.propMode && e.handlePropKey(t.Keysym.Sym) {
+				continue
 			}
-		case *sdl.MouseWheelEvent:
-			e.camera.AdjustZoom(float64(t.PreciseY))
+			e.pressInput(keyInput(t.Keysym.Sym))
+		default:
+			e.handleActionEvent(event)
 		}
 	}
 }
 
 func (e *Engine) update() {
-	frameCount++
+	e.frameCount++
 	// Variable timestep
-	if wait := MSPerFrame - (sdl.GetTicks() - previous); wait > 0 && wait <= MSPerFrame {
+	if wait := MSPerFrame - (sdl.GetTicks() - e.previous); wait > 0 && wait <= MSPerFrame {
 		sdl.Delay(wait)
 	}
 
-	delta = float64(sdl.GetTicks() - previous)
-	if frameCount > 10 {
-		e.window.SetTitle(fmt.Sprintf("FPS: %.2f", 1000.0/delta))
-		frameCount = 0
+	e.delta = float64(sdl.GetTicks() - e.previous)
+	if e.frameCount > 10 {
+		e.window.SetTitle(fmt.Sprintf("FPS: %.2f", 1000.0/e.delta))
+		e.frameCount = 0
 	}
-	delta = delta / 1000.0
-
-	previous = sdl.GetTicks()
+	e.delta = e.delta / 1000.0
 
-	if autorotate {
-		model.mesh.rotation.y += 0.5 * delta
-	}
+	e.previous = sdl.GetTicks()
 
-	matrix := MatrixScale(Vec3{modelScale, modelScale, modelScale})
-	lights := make([]DirectionalLight, len(model.mesh.directionalLights))
-	for i, light := range model.mesh.directionalLights {
-		light.position = matrix.MulVec3(light.position)
-		lights[i] = light
+	if e.autorotate {
+		e.mapNode.rotation.y += 0.5 * e.delta
 	}
 
-	model.UpdateMatrix()
-	for _, triangle := range model.mesh.triangles {
-		var vertices [3]Vec3
+	e.updateAnalog()
+	e.receiveMaps()
+	e.receiveCatalog()
 
-		// Transform vertices with World Matrix
-		for i, vertex := range triangle.vertices {
-			vertex = model.Matrix().MulVec3(vertex)
-			vertices[i] = vertex
-		}
-
-		normal := verticesNormal(vertices)
-		for _, light := range lights {
-			intensity := -normal.Dot(vertices[0].Sub(light.position).Normalize())
-			color := light.color.Scale(intensity)
-			triangle.lightColor = triangle.lightColor.Add(color)
-		}
-		triangle.lightColor = triangle.lightColor.Add(model.mesh.ambientLight.color).Scale(2.0)
-
-		for i, vertex := range vertices {
-			vertex = e.camera.ViewMatrix().MulVec3(vertex)
-			vertices[i] = vertex
-		}
-
-		// Calculate average depth after vertex is in view space.
-		a, b, c := vertices[0], vertices[1], vertices[2]
-		triangle.avgDepth = (a.z + b.z + c.z) / 3.0
-
-		// Project vertices with Projection Matrix.
-		for i, vertex := range vertices {
-			// Projection
-			vertex := e.camera.ProjectionMatrix().MulVec4(vertex.Vec4())
-
-			// Perspective divide is using perspective projection.
-			if e.camera.projection == Perspective {
-				if vertex.w != 0 {
-					vertex.x /= vertex.w
-					vertex.y /= vertex.w
-					vertex.z /= vertex.w
-				}
-			}
-
-			// Invert the Y asis to compensate for the Y axis of the model and
-			// the color buffer being different (+Y up vs +Y down, respectively).
-			vertex.y *= -1
+	if e.browserOpen {
+		e.updateBrowser()
+		return
+	}
+	if e.flyMode && !e.pickerOpen && !e.imageMode {
+		e.updateFly()
+	}
+	e.camera.Update(e.delta)
+	if e.gameMode {
+		e.updateGameCamera()
+	}
 
-			// Scale to the viewport
-			vertex.x *= float64(e.window.width / 2)
-			vertex.y *= float64(e.window.height / 2)
+	e.faceCamera()
+	e.transformScene()
+}
 
-			// Translate to center of screen
-			vertex.x += float64(e.window.width / 2)
-			vertex.y += float64(e.window.height / 2)
+func (e *Engine) render() {
+	if e.imageMode {
+		e.renderImage()
+		return
+	}
+	if e.browserOpen {
+		e.renderBrowser()
+		return
+	}
 
-			vertices[i] = vertex.Vec3()
-		}
+	// Draw
+	e.renderer.DrawTriangles(e.trianglesToRender, e.options)
 
-		if shouldCull(vertices) {
-			continue
+	if e.options.showWireframe {
+		for _, t := range e.trianglesToRender {
+			t.color = Magenta
+			e.renderer.DrawTriangle(t)
 		}
+	}
 
-		for i, vertex := range vertices {
-			triangle.points[i] = Vec2{vertex.x, vertex.y}
-		}
+	// e.renderer.DrawOriginAxis(e.camera)
 
-		model.trianglesToRender = append(model.trianglesToRender, triangle)
+	if e.loadingMap != 0 {
+		e.window.SetText(e.window.width-200, 10, fmt.Sprintf("Loading MAP%03d...", e.loadingMap), Yellow)
 	}
 
-	// Painters algorithm. Sort the projected triangles so the ones further away are
-	// rendered first. This is based on the average of a triangles vertices so there
-	// are visual issues. A depth buffer will solve this issue.
-	sort.Slice(model.trianglesToRender, func(i, j int) bool {
-		return model.trianglesToRender[i].avgDepth > model.trianglesToRender[j].avgDepth
-	})
-}
+	if e.propMode {
+		e.drawTileCursor()
+		c := e.tileCursor
+		e.window.SetText(10, e.window.height-50, fmt.Sprintf("Prop: %s  Tile: %d,%d Level: %d", e.propObj, c.X, c.Z, c.Level), White)
+		e.window.SetText(10, e.window.height-25, "[Arrows] Move [Tab] Level [Enter] Place [Backspace] Remove [,/.] Rotate [O] Done", White)
+	}
 
-func (e *Engine) render() {
-	// Draw
-	for _, t := range model.trianglesToRender {
-		if showTexture {
-			e.renderer.DrawTexturedTriangle(t, model.mesh.texture)
-		} else {
-			e.renderer.DrawFilledTriangle(t)
+	if e.flyMode {
+		y := e.window.height - 25
+		if e.propMode {
+			y -= 50
 		}
+		e.window.SetText(10, y, e.flyHelp(), White)
+	}
 
-		if showWireframe {
-			t.color = Magenta
-			e.renderer.DrawTriangle(t)
+	if e.gameMode {
+		y := e.window.height - 25
+		if e.propMode {
+			y -= 50
 		}
-
-		// e.renderer.DrawOriginAxis(e.camera)
+		text := "Game camera: " + e.keyLabel(ActionTurnLeft, "Left") + " " + e.keyLabel(ActionTurnRight, "Right") + " " +
+			e.keyLabel(ActionToggleTilt, "Tilt") + " " + e.keyLabel(ActionToggleGameCamera, "Done")
+		e.window.SetText(10, y, text, White)
 	}
 
-	var (
-		textHelp       = "[H]elp: Show"
-		textProj       = "[P]rojection: "
-		textBackground = "[B]ackground: "
-		textLighting   = "[L]ighting: "
-		textTexture    = "[T]exture: "
-		textWireframe  = "[W]ireframe: "
-		textAutorotate = "[A]uto rotate: "
-	)
-	if showHelp {
+	if e.pickerOpen {
+		e.renderPicker()
+	} else if e.showHelp {
+		textProj := e.keyLabel(ActionToggleProjection, "Projection: ")
 		if e.camera.projection == Orthographic {
 			textProj += "Orthographic"
 		} else {
 			textProj += "Perspective"
 		}
-		if showMapBackground {
+		textBackground := e.keyLabel(ActionToggleBackground, "Background: ")
+		if e.showMapBackground {
 			textBackground += "Map"
 		} else {
 			textBackground += "Default"
 		}
-		if showLighting {
+		textLighting := e.keyLabel(ActionToggleLighting, "Lighting: ")
+		if e.options.showLighting {
 			textLighting += "Enabled"
 		} else {
 			textLighting += "Disabled"
 		}
-		if showTexture {
+		textTexture := e.keyLabel(ActionToggleTexture, "Texture: ")
+		if e.options.showTexture {
 			textTexture += "Show"
 		} else {
 			textTexture += "Hide"
 		}
-		if showWireframe {
+		textShading := e.keyLabel(ActionToggleGouraud, "Gouraud: ")
+		if e.options.smoothShading {
+			textShading += "On"
+		} else {
+			textShading += "Off"
+		}
+		textFilter := e.keyLabel(ActionToggleFilter, "Filter: ")
+		if e.options.bilinearFilter {
+			textFilter += "Bilinear"
+		} else {
+			textFilter += "Nearest"
+		}
+		textSSAA := e.keyLabel(ActionCycleSupersampling, "SSAA: ")
+		if e.window.ssaa > 1 {
+			textSSAA += fmt.Sprintf("%dx", e.window.ssaa)
+		} else {
+			textSSAA += "Off"
+		}
+		textWireframe := e.keyLabel(ActionToggleWireframe, "Wireframe: ")
+		if e.options.showWireframe {
 			textWireframe += "Show"
 		} else {
 			textWireframe += "Hide"
 		}
-		if autorotate {
+		textAutorotate := e.keyLabel(ActionToggleAutorotate, "Auto rotate: ")
+		if e.autorotate {
 			textAutorotate += "On"
 		} else {
 			textAutorotate += "Off"
 		}
-		e.window.TextBackground(200, 250, Color{255, 255, 255, 30})
-		e.window.SetText(10, 10, textHelp, White)
+		textScenario := e.keyLabel(ActionNextScenario, "Scenario: ")
+		if e.currentScenario < len(e.scenarios) {
+			textScenario += fmt.Sprintf("%d/%d %s", e.currentScenario+1, len(e.scenarios), e.scenarios[e.currentScenario])
+		}
+		textFly := e.keyLabel(ActionToggleFly, "Fly: ")
+		if e.flyMode {
+			textFly += "On"
+		} else {
+			textFly += "Off"
+		}
+		textGame := e.keyLabel(ActionToggleGameCamera, "Game camera: ") + e.gameCameraText()
+		textMaps := e.keyLabel(ActionPrevMap, "Previous") + " " + e.keyLabel(ActionNextMap, "Next")
+
+		e.window.TextBackground(200, 580, Color{255, 255, 255, 30})
+		e.window.SetText(10, 10, e.keyLabel(ActionToggleHelp, "Help: Show"), White)
 		e.window.SetText(10, 40, textProj, White)
 		e.window.SetText(10, 70, textTexture, White)
-		e.window.SetText(10, 100, textLighting, White)
-		e.window.SetText(10, 130, textWireframe, White)
-		e.window.SetText(10, 160, textBackground, White)
-		e.window.SetText(10, 190, textAutorotate, White)
-		e.window.SetText(10, 220, "[J/K] Next/Previous", White)
+		e.window.SetText(10, 100, textFilter, White)
+		e.window.SetText(10, 130, textSSAA, White)
+		e.window.SetText(10, 160, textLighting, White)
+		e.window.SetText(10, 190, textShading, White)
+		e.window.SetText(10, 220, textWireframe, White)
+		e.window.SetText(10, 250, textBackground, White)
+		e.window.SetText(10, 280, textAutorotate, White)
+		e.window.SetText(10, 310, textMaps, White)
+		e.window.SetText(10, 340, e.keyLabel(ActionPlaceProps, "Place props"), White)
+		e.window.SetText(10, 370, e.keyLabel(ActionImages, "Images"), White)
+		e.window.SetText(10, 400, e.keyLabel(ActionFindMap, "Find map"), White)
+		e.window.SetText(10, 430, e.keyLabel(ActionBrowseMaps, "Browse maps"), White)
+		e.window.SetText(10, 460, textScenario, White)
+		e.window.SetText(10, 490, textFly, White)
+		e.window.SetText(10, 520, textGame, White)
+		e.window.SetText(10, 550, e.keyLabel(ActionFitView, "Fit view"), White)
 	}
 	// Present
 	e.window.Present()
-
-	// Clear triangles from last frame
-	model.trianglesToRender = model.trianglesToRender[:0]
 }
 
-func (e *Engine) loadObj(file string) {
-	model.mesh = NewMeshFromObj(file)
+// loadObj adds a wavefront obj file to the scene and returns its node.
+func (e *Engine) loadObj(file string) (*Node, error) {
+	mesh, err := NewMeshFromObj(file)
+	if err != nil {
+		return nil, err
+	}
+	return e.scene.root.AddChild(NewNode(file, NewModel(mesh))), nil
 }
 
 func (e *Engine) toggleBackgorund() {
-	showMapBackground = !showMapBackground
-	if showMapBackground {
+	e.showMapBackground = !e.showMapBackground
+	if e.showMapBackground {
 		e.updateBackgroundTexture()
 	} else {
 		e.window.SetDefaultBackground()
 	}
 }
 
-func (e *Engine) setMap(n int) {
-	currentMap = n
-	model.mesh = e.reader.ReadMesh(n)
+// cycleSupersampling steps through the supersampling factors (off, 2x, 3x, 4x).
+func (e *Engine) cycleSupersampling() {
+	factor := e.window.ssaa + 1
+	if factor > maxSupersampling {
+		factor = 1
+	}
+	e.window.SetSupersampling(factor)
+}
+
+// mapModel returns the model of the current map.
+func (e *Engine) mapModel() *Model {
+	return e.mapNode.model
+}
+
+// setMap switches to a map in one of its scenarios. Cached maps are shown
+// immediately, others are loaded in the background and shown once they are
+// ready. Maps that failed to load before are read again. See loader.go.
+func (e *Engine) setMap(n, scenario int) {
+	if mesh, ok := e.loader.cached(n, scenario); ok {
+		e.loadingMap = 0
+		e.showMap(n, scenario, mesh)
+		return
+	}
+	e.loadingMap, e.loadingScenario = n, scenario
+	e.loader.forget(n, scenario)
+	e.loader.load(n, scenario)
+}
+
+// receiveMaps shows the map being loaded once it is ready.
+func (e *Engine) receiveMaps() {
+	for {
+		select {
+		case loaded := <-e.loader.results:
+			if loaded.mapNum != e.loadingMap || loaded.scenario != e.loadingScenario {
+				continue
+			}
+			e.loadingMap = 0
+			if loaded.err != nil {
+				fmt.Fprintln(os.Stderr, "load map:", loaded.err)
+				continue
+			}
+			e.showMap(loaded.mapNum, loaded.scenario, loaded.mesh)
+		default:
+			return
+		}
+	}
+}
 
-	// Center camera on center of obj
-	center := model.mesh.coordCenter().Mul(modelScale)
-	e.camera.front = center
-	e.camera.updateViewMatrix()
+// showMap replaces the current map with a loaded mesh and prefetches its
+// neighbors.
+func (e *Engine) showMap(n, scenario int, mesh Mesh) {
+	e.currentMap = n
+	e.currentScenario = scenario
+	scenarios, err := e.reader.ReadScenarios(n)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "read scenarios:", err)
+	}
+	e.scenarios = scenarios
+	e.mapNode.model = NewModel(mesh)
+	e.tileCursor = TilePosition{}
+	e.loadProps()
+	e.loadUnits()
+
+	if e.restoreCamera != nil {
+		_, _, zoom := e.camera.Fit(e.mapBounds())
+		e.camera.fitZoomLimits(zoom)
+		e.restoreCamera.apply(e.camera)
+		e.restoreCamera = nil
+		if e.gameMode {
+			// The camera may have been saved while turning.
+			e.animateGameCamera()
+		}
+	} else {
+		e.fitMap()
+	}
 
-	if showMapBackground {
+	if e.showMapBackground {
 		e.updateBackgroundTexture()
 	}
+
+	if prev, ok := e.neighborMap(n, -1); ok {
+		e.loader.load(prev, 0)
+	}
+	if next, ok := e.neighborMap(n, 1); ok {
+		e.loader.load(next, 0)
+	}
+}
+
+// fitMap moves the camera so the map fills the view, looking from the same
+// direction.
+func (e *Engine) fitMap() {
+	eye, front, zoom := e.camera.Fit(e.mapBounds())
+	e.camera.fitZoomLimits(zoom)
+	e.camera.MoveTo(eye, front, zoom)
+}
+
+// mapBounds returns the corners of the bounding box and the bounding sphere of
+// the map in world space, where it may be rotated by autorotate.
+func (e *Engine) mapBounds() ([8]Vec3, Sphere) {
+	mesh := e.mapModel().mesh
+	matrix := e.mapNode.LocalMatrix().Mul(MatrixWorld(mesh.scale, mesh.rotation, mesh.translation))
+	return mesh.bounds().Transform(matrix), mesh.boundingSphere().Transform(matrix)
 }
 
 func (e *Engine) updateBackgroundTexture() {
-	bg := model.mesh.background
+	bg := e.mapModel().mesh.background
 	bgBuffer := make([]Color, e.window.width*e.window.height)
 	for y := 0; y < e.window.height; y++ {
 		color := bg.At(y, e.window.height)
@@ -320,25 +487,54 @@ func (e *Engine) updateBackgroundTexture() {
 	e.window.bgTexture.Update(nil, unsafe.Pointer(&bgBuffer[0]), e.window.width*4)
 }
 
+// neighborMap returns the closest existing map before (step -1) or after
+// (step 1) map n.
+func (e *Engine) neighborMap(n, step int) (int, bool) {
+	for n += step; n >= firstMap && n <= lastMap; n += step {
+		if e.reader.HasMap(n) {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// prevMap loads the previous map, skipping maps that don't exist. While a map
+// is loading it steps from that map.
 func (e *Engine) prevMap() {
-	if currentMap > 1 {
-		e.setMap(currentMap - 1)
+	if n, ok := e.neighborMap(e.targetMap(), -1); ok {
+		e.setMap(n, 0)
 	}
 }
 
+// nextMap loads the next map, skipping maps that don't exist.
 func (e *Engine) nextMap() {
-	if currentMap < 125 {
-		e.setMap(currentMap + 1)
+	if n, ok := e.neighborMap(e.targetMap(), 1); ok {
+		e.setMap(n, 0)
+	}
+}
+
+// nextScenario shows the current map in its next scenario.
+func (e *Engine) nextScenario() {
+	if len(e.scenarios) > 1 {
+		e.setMap(e.currentMap, (e.currentScenario+1)%len(e.scenarios))
 	}
 }
 
+// targetMap returns the map being loaded, or the current map.
+func (e *Engine) targetMap() int {
+	if e.loadingMap != 0 {
+		return e.loadingMap
+	}
+	return e.currentMap
+}
+
 // Backface culling
 //
 // This works by checking the winding order of the projected triangle.
 // If its CCW then you can ignore this triangle since it would be back-facing.
 //
 // NOTE: This method must be done after projection vertices.
-func shouldCull(vertices [3]Vec3) bool {
+func shouldCull(vertices [3]Vec2) bool {
 	a, b, c := vertices[0], vertices[1], vertices[2]
 	ab, ac := b.Sub(a), c.Sub(a)
 
@@ -349,6 +545,17 @@ func shouldCull(vertices [3]Vec3) bool {
 	return false
 }
 
+// lightColor returns the color of the lights hitting a surface with the given
+// normal at position, including the ambient light.
+func lightColor(normal, position Vec3, lights []DirectionalLight, ambient AmbientLight) Color {
+	var color Color
+	for _, light := range lights {
+		intensity := -normal.Dot(position.Sub(light.position).Normalize())
+		color = color.Add(light.color.Scale(intensity))
+	}
+	return color.Add(ambient.color).Scale(2.0)
+}
+
 func verticesNormal(vv [3]Vec3) Vec3 {
 	a := vv[0]
 	b := vv[1]
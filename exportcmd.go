@@ -0,0 +1,77 @@
+// This file contains the export command for converting maps to wavefront obj
+// files.
+//
+//	go run *.go export [-o dir] [-scenario n] <path-to-bin> [map]...
+//
+// Each map is written as mapNNN.obj with a material library and a PNG texture
+// per palette. Without map numbers every map on the disc is exported.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("o", exportsDir, "output directory")
+	scenario := fs.Int("scenario", 0, "scenario of the maps, in the order listed by the info command")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go run *.go export [-o dir] [-scenario n] <path-to-bin> [map]...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	reader, err := NewReader(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	maps := []int{}
+	for _, arg := range fs.Args()[1:] {
+		n, err := strconv.Atoi(arg)
+		if err != nil || !reader.HasMap(n) {
+			return fmt.Errorf("map %s is not on the disc", arg)
+		}
+		maps = append(maps, n)
+	}
+	if len(maps) == 0 {
+		for n := firstMap; n <= lastMap; n++ {
+			if reader.HasMap(n) {
+				maps = append(maps, n)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	for _, n := range maps {
+		scenarios, err := reader.ReadScenarios(n)
+		if err != nil {
+			return err
+		}
+		if count := len(scenarios); *scenario >= count {
+			fmt.Fprintf(os.Stderr, "skipping map %d: it has %d scenarios\n", n, count)
+			continue
+		}
+		mesh, err := reader.ReadMesh(n, *scenario)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("map%03d", n)
+		if err := WriteObj(mesh, *out, name); err != nil {
+			return err
+		}
+		fmt.Println("exported", name)
+	}
+	return nil
+}
@@ -31,6 +31,15 @@ func (t RecordType) String() string {
 	}
 }
 
+// known returns true for the record types above.
+func (t RecordType) known() bool {
+	switch t {
+	case RecordTypeTexture, RecordTypeMeshPrimary, RecordTypeMeshOverride, RecordTypeMeshAlt, RecordTypeEnd:
+		return true
+	}
+	return false
+}
+
 const (
 	RecordTypeTexture      RecordType = 0x1701
 	RecordTypeMeshPrimary  RecordType = 0x2E01
@@ -108,6 +117,10 @@ func (r GNSRecord) Weather() MapWeather {
 	return MapWeather(int((r[3] >> 4) & 0x7))
 }
 
+func (r GNSRecord) Scenario() Scenario {
+	return Scenario{r.Time(), r.Weather()}
+}
+
 var GNSSectors = [126]int64{
 	10026, // MAP000.GNS
 	11304, // MAP001.GNS
@@ -236,3 +249,17 @@ var GNSSectors = [126]int64{
 	0,     // MAP124.GNS
 	56435, // MAP125.GNS
 }
+
+// parseGNSRecords returns the records of a GNS file up to the end record. It
+// returns false if there is no end record in data.
+func parseGNSRecords(data []byte) ([]GNSRecord, bool) {
+	records := []GNSRecord{}
+	for offset := 0; offset+GNSRecordLen <= len(data); offset += GNSRecordLen {
+		record := GNSRecord(data[offset : offset+GNSRecordLen])
+		if record.Type() == RecordTypeEnd {
+			return records, true
+		}
+		records = append(records, record)
+	}
+	return records, false
+}
@@ -0,0 +1,344 @@
+// This file contains the map catalog.
+//
+// Maps are numbered by their GNS file (MAP049.GNS is map 49). The names follow
+// the FFHacktics map list and the areas are the regions of Ivalice the maps are
+// in. Scenarios, polygon counts and whether a map is a battle map are read from
+// the disc, since they differ between releases.
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	firstMap = 1
+	lastMap  = 125
+)
+
+type mapName struct {
+	name, area string
+}
+
+var mapNames = [126]mapName{
+	{"Unknown", ""},
+	{"At Main Gate of Igros Castle", "Gallione"},
+	{"Back Gate of Lesalia Castle", "Lesalia"},
+	{"Hall of St. Murond Temple", "Murond"},
+	{"Office of Lesalia Castle", "Lesalia"},
+	{"Roof of Riovanes Castle", "Fovoham"},
+	{"At the Gate of Riovanes Castle", "Fovoham"},
+	{"Inside of Riovanes Castle", "Fovoham"},
+	{"Riovanes Castle", "Fovoham"},
+	{"Citadel of Igros Castle", "Gallione"},
+	{"Inside of Igros Castle", "Gallione"},
+	{"Office of Igros Castle", "Gallione"},
+	{"At the Gate of Lionel Castle", "Lionel"},
+	{"Inside of Lionel Castle", "Lionel"},
+	{"Office of Lionel Castle", "Lionel"},
+	{"At the Gate of Limberry Castle (1)", "Limberry"},
+	{"Inside of Limberry Castle", "Limberry"},
+	{"Underground Cemetery of Limberry Castle", "Limberry"},
+	{"Office of Limberry Castle", "Limberry"},
+	{"At the Gate of Limberry Castle (2)", "Limberry"},
+	{"Inside of Zeltennia Castle", "Zeltennia"},
+	{"Zeltennia Castle", "Zeltennia"},
+	{"Magic City Gariland", "Gallione"},
+	{"Belouve Residence", "Gallione"},
+	{"Military Academy's Auditorium", "Gallione"},
+	{"Yardow Fort City", "Fovoham"},
+	{"Weapon Storehouse", "Fovoham"},
+	{"Goland Coal City", "Lesalia"},
+	{"Colliery Underground First Floor", "Lesalia"},
+	{"Colliery Underground Second Floor", "Lesalia"},
+	{"Colliery Underground Third Floor", "Lesalia"},
+	{"Dorter Trade City", "Gallione"},
+	{"Slums in Dorter", "Gallione"},
+	{"Hospital in Slums", "Gallione"},
+	{"Cellar of Sand Mouse", "Gallione"},
+	{"Zaland Fort City", "Lesalia"},
+	{"Church Outside of Town", "Lesalia"},
+	{"Ruins Outside Zaland", "Lesalia"},
+	{"Goug Machine City", "Lionel"},
+	{"Underground Passage in Goland", "Lesalia"},
+	{"Slums in Goug", "Lionel"},
+	{"Besselat's House", "Lionel"},
+	{"Warjilis Trade City", "Lionel"},
+	{"Port of Warjilis", "Lionel"},
+	{"Bervenia Free City", "Zeltennia"},
+	{"Ruins of Zeltennia Castle's Church", "Zeltennia"},
+	{"Cemetery of Heavenly Knight, Balbanes", "Zeltennia"},
+	{"Zarghidas Trade City", "Zeltennia"},
+	{"Slums of Zarghidas", "Zeltennia"},
+	{"Fort Zeakden", "Gallione"},
+	{"St. Murond Temple", "Murond"},
+	{"St. Murond Temple", "Murond"},
+	{"Chapel of St. Murond Temple", "Murond"},
+	{"Entrance to Death City", "Murond"},
+	{"Lost Sacred Precincts", "Murond"},
+	{"Graveyard of Airships", "Murond"},
+	{"Orbonne Monastery", "Lesalia"},
+	{"Underground Book Storage First Floor", "Lesalia"},
+	{"Underground Book Storage Second Floor", "Lesalia"},
+	{"Underground Book Storage Third Floor", "Lesalia"},
+	{"Underground Book Storage Fourth Floor", "Lesalia"},
+	{"Underground Book Storage Fifth Floor", "Lesalia"},
+	{"Chapel of Orbonne Monastery", "Lesalia"},
+	{"Golgorand Execution Site", "Lesalia"},
+	{"In Front of Bethla Garrison's Sluice", "Zeltennia"},
+	{"Granary of Bethla Garrison", "Zeltennia"},
+	{"South Wall of Bethla Garrison", "Zeltennia"},
+	{"North Wall of Bethla Garrison", "Zeltennia"},
+	{"Bethla Garrison", "Zeltennia"},
+	{"Murond Death City", "Murond"},
+	{"Nelveska Temple", "Lionel"},
+	{"Dolbodar Swamp", "Zeltennia"},
+	{"Fovoham Plains", "Fovoham"},
+	{"Inside of Windmill Shed", "Fovoham"},
+	{"Sweegy Woods", "Gallione"},
+	{"Bervenia Volcano", "Zeltennia"},
+	{"Zeklaus Desert", "Gallione"},
+	{"Lenalia Plateau", "Fovoham"},
+	{"Zigolis Swamp", "Lionel"},
+	{"Yuguo Woods", "Zeltennia"},
+	{"Araguay Woods", "Lesalia"},
+	{"Grog Hill", "Fovoham"},
+	{"Bed Desert", "Zeltennia"},
+	{"Zirekile Falls", "Lesalia"},
+	{"Bariaus Hill", "Lesalia"},
+	{"Mandalia Plains", "Gallione"},
+	{"Doguola Pass", "Limberry"},
+	{"Bariaus Valley", "Lionel"},
+	{"Finath River", "Zeltennia"},
+	{"Poeskas Lake", "Limberry"},
+	{"Germinas Peak", "Zeltennia"},
+	{"Thieves Fort", "Gallione"},
+	{"Igros-Belouve Residence", "Gallione"},
+	{"Broke Down Shed-Wooden Building", ""},
+	{"Broke Down Shed-Stone Building", ""},
+	{"Church", ""},
+	{"Pub", ""},
+	{"Inside Castle Gate in Lesalia", "Lesalia"},
+	{"Outside Castle Gate in Lesalia", "Lesalia"},
+	{"Main Street of Lesalia", "Lesalia"},
+	{"Public Cemetery", ""},
+	{"Tutorial (1)", ""},
+	{"Tutorial (2)", ""},
+	{"Windmill Shed", "Fovoham"},
+	{"Belouve Residence", "Gallione"},
+	{"TERMINATE", ""},
+	{"DELTA", ""},
+	{"NOGIAS", ""},
+	{"VOYAGE", ""},
+	{"BRIDGE", ""},
+	{"VALKYRIES", ""},
+	{"MLAPAN", ""},
+	{"TIGER", ""},
+	{"HORROR", ""},
+	{"END", ""},
+	{"Banished Fortress", ""},
+	{"Arena", ""},
+	{"Unknown", ""},
+	{"Unknown", ""},
+	{"Unknown", ""},
+	{"Missing", ""},
+	{"Missing", ""},
+	{"Missing", ""},
+	{"Missing", ""},
+	{"Missing", ""},
+	{"Unknown", ""},
+}
+
+// Scenario is a time and weather a map can be shown with.
+type Scenario struct {
+	time    MapTime
+	weather MapWeather
+}
+
+func (s Scenario) String() string {
+	return fmt.Sprintf("%s/%s", s.time, s.weather)
+}
+
+// hasWeather returns true if it is raining or snowing.
+func (s Scenario) hasWeather() bool {
+	return s.weather != WeatherNone && s.weather != WeatherNoneAlt
+}
+
+type MapInfo struct {
+	number    int
+	name      string
+	area      string
+	scenarios []Scenario
+	triangles int
+	quads     int
+	battle    bool
+}
+
+func (m MapInfo) String() string {
+	scenarios := make([]string, len(m.scenarios))
+	for i, s := range m.scenarios {
+		scenarios[i] = s.String()
+	}
+	kind := "event"
+	if m.battle {
+		kind = "battle"
+	}
+	return fmt.Sprintf("%3d  %-40s %-10s %-6s %5d tris %5d quads  %s",
+		m.number, m.name, m.area, kind, m.triangles, m.quads, strings.Join(scenarios, ", "))
+}
+
+// recordScenarios returns the distinct scenarios of the GNS records of a map in
+// the order they first appear.
+func recordScenarios(records []GNSRecord) []Scenario {
+	scenarios := []Scenario{}
+	seen := map[Scenario]bool{}
+	for _, record := range records {
+		scenario := record.Scenario()
+		if !seen[scenario] {
+			seen[scenario] = true
+			scenarios = append(scenarios, scenario)
+		}
+	}
+	return scenarios
+}
+
+// ReadScenarios returns the scenarios of a map.
+func (r Reader) ReadScenarios(mapNum int) ([]Scenario, error) {
+	records, err := r.variant.readGNSRecords(r, mapNum)
+	if err != nil {
+		return nil, err
+	}
+	return recordScenarios(records), nil
+}
+
+// HasMap returns true if the map exists in the image.
+func (r Reader) HasMap(mapNum int) bool {
+	return mapNum >= firstMap && mapNum <= lastMap && r.variant.hasMap(mapNum)
+}
+
+// ReadMapInfo reads the catalog entry of a map.
+//
+// Battle maps are the maps with terrain. The polygon counts are from the
+// primary mesh, or the override mesh for maps without one.
+func (r Reader) ReadMapInfo(mapNum int) (MapInfo, error) {
+	info := MapInfo{number: mapNum, name: mapNames[mapNum].name, area: mapNames[mapNum].area}
+
+	records, err := r.variant.readGNSRecords(r, mapNum)
+	if err != nil {
+		return MapInfo{}, err
+	}
+	info.scenarios = recordScenarios(records)
+
+	var mesh GNSRecord
+	for _, record := range records {
+		switch record.Type() {
+		case RecordTypeMeshPrimary:
+			mesh = record
+		case RecordTypeMeshOverride:
+			if mesh == nil {
+				mesh = record
+			}
+		}
+	}
+
+	if mesh != nil {
+		data, err := r.variant.readResource(r, mapNum, mesh)
+		if err != nil {
+			return MapInfo{}, err
+		}
+		f := MeshFile{data, 0}
+		if ptr := f.PtrPrimaryMesh(); ptr != 0 && ptr+meshHeaderLen <= int64(len(f.data)) {
+			header := meshHeader(f.data[ptr : ptr+meshHeaderLen])
+			info.triangles = header.N() + header.Q()
+			info.quads = header.P() + header.R()
+		}
+		info.battle = f.PtrTerrain() != 0
+	}
+	return info, nil
+}
+
+// ReadCatalog reads the catalog entries of all maps in the image.
+func (r Reader) ReadCatalog() ([]MapInfo, error) {
+	catalog := []MapInfo{}
+	for n := firstMap; n <= lastMap; n++ {
+		if !r.HasMap(n) {
+			continue
+		}
+		info, err := r.ReadMapInfo(n)
+		if err != nil {
+			return nil, err
+		}
+		catalog = append(catalog, info)
+	}
+	return catalog, nil
+}
+
+// searchStopWords are ignored so queries can be written naturally, like
+// "night maps with weather".
+var searchStopWords = map[string]bool{
+	"map": true, "maps": true, "with": true, "and": true, "in": true, "the": true,
+}
+
+// SearchMaps returns the maps matching all words of a query.
+//
+// The words "day", "night" and "weather" must all match the same scenario.
+// "battle" and "event" match the kind of map. A number matches the map
+// number. Any other word matches part of the name or area.
+func SearchMaps(catalog []MapInfo, query string) []MapInfo {
+	var words []string
+	var day, night, weather, battle, event bool
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		switch word {
+		case "day":
+			day = true
+		case "night":
+			night = true
+		case "weather", "rain", "snow":
+			weather = true
+		case "battle":
+			battle = true
+		case "event":
+			event = true
+		default:
+			if !searchStopWords[word] {
+				words = append(words, word)
+			}
+		}
+	}
+
+	matches := []MapInfo{}
+	for _, m := range catalog {
+		if (battle && !m.battle) || (event && m.battle) {
+			continue
+		}
+
+		scenarioMatch := !day && !night && !weather
+		for _, s := range m.scenarios {
+			if (!day || s.time == TimeDay) && (!night || s.time == TimeNight) && (!weather || s.hasWeather()) {
+				scenarioMatch = true
+				break
+			}
+		}
+		if !scenarioMatch {
+			continue
+		}
+
+		text := strings.ToLower(m.name + " " + m.area)
+		wordMatch := true
+		for _, word := range words {
+			if n, err := strconv.Atoi(word); err == nil {
+				wordMatch = n == m.number
+			} else {
+				wordMatch = strings.Contains(text, word)
+			}
+			if !wordMatch {
+				break
+			}
+		}
+		if wordMatch {
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
@@ -3,9 +3,11 @@ package main
 
 import (
 	"encoding/binary"
-	"log"
+	"errors"
+	"fmt"
+	"io"
 	"math"
-	"os"
+	"sync"
 )
 
 const (
@@ -48,49 +50,141 @@ const (
 	textureRawLen int = textureLen / 2
 )
 
+// sectorCacheSize is the number of sectors kept in memory. Map files are read
+// more than once, for the catalog, thumbnails and the map itself.
+const sectorCacheSize = 4096
+
+// Reader reads the game files from a disc image. It is safe for concurrent use.
 type Reader struct {
-	file *os.File
+	data   io.ReaderAt
+	closer io.Closer // Optional
+
+	// Sector layout of the image. Raw PSX bin files have 2352 byte sectors with
+	// a header before the 2048 bytes of user data. ISO images, like the PSP
+	// release, only have the user data.
+	sectorRawSize    int64
+	sectorHeaderSize int64
+
+	sectors *sectorCache
+
+	// The release of the game. See variant.go.
+	variant formatVariant
 }
 
-func NewReader(filename string) *Reader {
-	f, err := os.Open(filename)
+// NewReader opens a disc image file. The image may be inside a zip or gzip
+// file, or referenced by a cue sheet. See archive.go.
+func NewReader(filename string) (*Reader, error) {
+	data, closer, err := openImage(filename)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return &Reader{f}
+	r, err := NewReaderAt(data)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	r.closer = closer
+	return r, nil
+}
+
+// NewReaderAt reads a disc image from any io.ReaderAt, like a file or a byte
+// slice in memory.
+func NewReaderAt(data io.ReaderAt) (*Reader, error) {
+	r := &Reader{
+		data:    data,
+		sectors: &sectorCache{cache: newLRUCache[int64, []byte](sectorCacheSize * sectorSize)},
+	}
+	if err := r.detectLayout(); err != nil {
+		return nil, err
+	}
+	variant, err := detectVariant(*r)
+	if err != nil {
+		return nil, err
+	}
+	r.variant = variant
+	return r, nil
 }
 
 func (r Reader) Close() {
-	r.file.Close()
+	if r.closer != nil {
+		r.closer.Close()
+	}
 }
 
-// seekSector will seek to the specified sector of the file.
-func (r Reader) seekSector(sector int64) {
-	to := sector*sectorRawSize + sectorHeaderSize
-	_, err := r.file.Seek(to, 0)
-	if err != nil {
-		log.Fatalf("seek to sector: %v", err)
+// detectLayout sets the sector layout by looking for the primary volume
+// descriptor of the ISO 9660 filesystem.
+func (r *Reader) detectLayout() error {
+	layouts := [][2]int64{
+		{sectorRawSize, sectorHeaderSize},
+		{sectorSize, 0},
 	}
+	for _, layout := range layouts {
+		id := make([]byte, 5)
+		offset := isoPrimaryVolumeSector*layout[0] + layout[1] + 1
+		if n, _ := r.data.ReadAt(id, offset); n == len(id) && string(id) == "CD001" {
+			r.sectorRawSize, r.sectorHeaderSize = layout[0], layout[1]
+			return nil
+		}
+	}
+	return errors.New("unknown image format: no ISO 9660 filesystem found")
 }
 
-func (r Reader) readSector(sector int64) []byte {
-	r.seekSector(sector)
+// readSector reads the user data of a sector. The returned slice is a copy
+// that the caller may modify.
+func (r Reader) readSector(sector int64) ([]byte, error) {
 	data := make([]byte, sectorSize)
-	n, err := r.file.Read(data)
-	if err != nil || n != sectorSize {
-		log.Fatal("failed to read sector data", err)
+	if cached, ok := r.sectors.get(sector); ok {
+		copy(data, cached)
+		return data, nil
 	}
-	return data
+
+	// ReadAt doesn't share a file offset, so sectors can be read from
+	// multiple goroutines at once. It is allowed to return io.EOF along with
+	// the last full sector.
+	n, err := r.data.ReadAt(data, sector*r.sectorRawSize+r.sectorHeaderSize)
+	if n != sectorSize {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("read sector %d: %w", sector, err)
+	}
+
+	cached := make([]byte, sectorSize)
+	copy(cached, data)
+	r.sectors.add(sector, cached)
+	return data, nil
 }
 
-func (r Reader) readFile(sector int64, size int64) []byte {
+func (r Reader) readFile(sector int64, size int64) ([]byte, error) {
 	occupiedSectors := int64(math.Ceil(float64(size) / float64(sectorSize)))
 	data := make([]byte, 0)
 	for i := int64(0); i < occupiedSectors; i++ {
-		sectorData := r.readSector(sector + i)
+		sectorData, err := r.readSector(sector + i)
+		if err != nil {
+			return nil, err
+		}
 		data = append(data, sectorData...)
 	}
-	return data[0:size]
+	return data[0:size], nil
+}
+
+// sectorCache is a bounded cache of sector user data shared by all copies of a
+// Reader.
+type sectorCache struct {
+	mu    sync.Mutex
+	cache *lruCache[int64, []byte]
+}
+
+func (c *sectorCache) get(sector int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cache.get(sector)
+}
+
+func (c *sectorCache) add(sector int64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache.add(sector, data, len(data))
 }
 
 //
@@ -129,46 +223,84 @@ func (h meshHeader) TT() int {
 	return h.N() + h.P()*2
 }
 
-func (r Reader) ReadMesh(mapNum int) Mesh {
-	records := r.readGNSRecords(mapNum)
+// ReadMesh reads a map as it looks in one of its scenarios. Scenarios are
+// numbered in the order of ReadScenarios. The texture and override mesh of the
+// scenario are used if the map has them, otherwise the first texture and the
+// primary mesh.
+func (r Reader) ReadMesh(mapNum, scenario int) (Mesh, error) {
+	records, err := r.variant.readGNSRecords(r, mapNum)
+	if err != nil {
+		return Mesh{}, err
+	}
 
-	textures := []Texture{}
-	var mesh Mesh
+	var want Scenario
+	if scenarios := recordScenarios(records); scenario >= 0 && scenario < len(scenarios) {
+		want = scenarios[scenario]
+	}
 
+	var texture, primary, override GNSRecord
 	for _, record := range records {
 		switch record.Type() {
 		case RecordTypeTexture:
-			textures = append(textures, r.parseTexture(record))
+			// The first texture, or the first one of the scenario.
+			if texture == nil || record.Scenario() == want && texture.Scenario() != want {
+				texture = record
+			}
 		case RecordTypeMeshPrimary:
-			mesh = r.parseMesh(record)
+			primary = record
 		case RecordTypeMeshOverride:
 			// Sometimes there is no primary mesh (ie MAP002.GNS), there is
-			// only an override. Usually a non-battle map. So we treat this
-			// one as the primary, only if the primary hasn't been set. Kinda
-			// Hacky until we start treating each GNS Record as a Scenario.
-			if len(mesh.triangles) == 0 {
-				mesh = r.parseMesh(record)
+			// only an override. Usually a non-battle map. So we use the
+			// first one unless one matches the scenario.
+			if override == nil || record.Scenario() == want && override.Scenario() != want {
+				override = record
 			}
 		}
 	}
 
-	if len(textures) > 0 {
-		mesh.texture = textures[0]
+	meshRecord := primary
+	if override != nil && (primary == nil || override.Scenario() == want) {
+		meshRecord = override
+	}
+
+	var mesh Mesh
+	if meshRecord != nil {
+		data, err := r.variant.readResource(r, mapNum, meshRecord)
+		if err != nil {
+			return Mesh{}, err
+		}
+		if mesh, err = r.parseMesh(meshRecord, data); err != nil {
+			return Mesh{}, fmt.Errorf("map %d: %w", mapNum, err)
+		}
+	}
+
+	if texture != nil {
+		data, err := r.variant.readResource(r, mapNum, texture)
+		if err != nil {
+			return Mesh{}, err
+		}
+		if mesh.texture, err = r.parseTexture(data); err != nil {
+			return Mesh{}, fmt.Errorf("map %d: %w", mapNum, err)
+		}
 	}
 	mesh.scale = Vec3{modelScale, modelScale, modelScale}
-	return mesh
+	return mesh, nil
 }
 
 // parseTexture reads and returns an FFT texture as an engine Texture.
-func (r Reader) parseTexture(record GNSRecord) Texture {
-	data := r.readFile(record.Sector(), record.Len())
+func (r Reader) parseTexture(data []byte) (Texture, error) {
+	if len(data) < textureRawLen {
+		return Texture{}, fmt.Errorf("texture is %d bytes instead of %d", len(data), textureRawLen)
+	}
 	pixels := textureSplitPixels(data)
-	return NewTexture(textureWidth, textureHeight, pixels)
+	return NewTexture(textureWidth, textureHeight, pixels), nil
 }
 
 // parseMesh reads mesh data for primary and alternate meshes.
-func (r Reader) parseMesh(record GNSRecord) Mesh {
-	data := r.readFile(record.Sector(), record.Len())
+func (r Reader) parseMesh(record GNSRecord, data []byte) (Mesh, error) {
+	if len(data) < meshFileHeaderLen {
+		return Mesh{}, errors.New("mesh file is shorter than its header")
+	}
 	f := MeshFile{data, 0}
 
 	// Primary mesh pointer tells us where the primary mesh data is.  I
@@ -181,7 +313,7 @@ func (r Reader) parseMesh(record GNSRecord) Mesh {
 	// (ie MAP002.GNS) don't have a primary mesh, only alternative. The location of that mesh
 	if record.Type() == RecordTypeMeshPrimary {
 		if primaryMeshPointer == 0 || primaryMeshPointer != 196 {
-			log.Fatal("missing primary mesh pointer")
+			return Mesh{}, errors.New("missing primary mesh pointer")
 		}
 	}
 
@@ -204,82 +336,75 @@ func (r Reader) parseMesh(record GNSRecord) Mesh {
 	header := meshHeader(f.data[f.offset : f.offset+meshHeaderLen])
 	f.offset += meshHeaderLen
 
-	// FIXME: Change capacity from TT to total with untextured.
-	triangles := make([]Triangle, 0, header.TT())
+	// Polygons are read in separate passes for positions, normals and texture
+	// data. Collect each per triangle and build the indexed mesh at the end.
+	total := header.TT() + header.Q() + header.R()*2
+	positions := make([][3]Vec3, 0, total)
+	normals := make([][3]Vec3, total)
+	texcoords := make([][3]Tex, total)
+	trianglePalettes := make([]Palette, total)
+
 	for i := 0; i < header.N(); i++ {
-		triangles = append(triangles, f.readTriangle())
+		positions = append(positions, f.readTriangle())
 	}
 	for i := 0; i < header.P(); i++ {
-		triangles = append(triangles, f.readQuad()...)
+		positions = append(positions, f.readQuad()...)
 	}
 	for i := 0; i < header.Q(); i++ {
-		triangles = append(triangles, f.readTriangle())
+		positions = append(positions, f.readTriangle())
 	}
 	for i := 0; i < header.R(); i++ {
-		triangles = append(triangles, f.readQuad()...)
+		positions = append(positions, f.readQuad()...)
 	}
 
 	// Normals
-	// Nothing is actually collected. They are just read here so the
-	// iso read position moves forward, so we can read polygon texture data
-	// next.  This could be cleaned up as a seek, but we may eventually use
-	// the normal data here.
+	// Only textured polygons have normals. Untextured polygons keep a zero
+	// normal and will be lit using their face normal.
 	for i := 0; i < header.N(); i++ {
-		triangles[i].normals = f.readTriNormal()
+		normals[i] = f.readTriNormal()
 	}
 	for i := header.N(); i < header.TT(); i = i + 2 {
 		qns := f.readQuadNormal()
-		triangles[i].normals = qns[0]
-		triangles[i+1].normals = qns[1]
+		normals[i] = qns[0]
+		normals[i+1] = qns[1]
 	}
 
 	// Polygon texture data
 	for i := 0; i < header.N(); i++ {
 		uv, palette := f.readTriUV()
-		triangles[i].texcoords = uv
-		triangles[i].palette = palettes[palette]
+		texcoords[i] = uv
+		trianglePalettes[i] = palettes[palette]
 	}
 	for i := header.N(); i < header.TT(); i = i + 2 {
 		uvs, palette := f.readQuadUV()
-		triangles[i].texcoords = uvs[0]
-		triangles[i].palette = palettes[palette]
+		texcoords[i] = uvs[0]
+		trianglePalettes[i] = palettes[palette]
+
+		texcoords[i+1] = uvs[1]
+		trianglePalettes[i+1] = palettes[palette]
+	}
 
-		triangles[i+1].texcoords = uvs[1]
-		triangles[i+1].palette = palettes[palette]
+	mesh := Mesh{}
+	builder := newMeshBuilder(&mesh)
+	for i := range positions {
+		builder.addFace(positions[i], normals[i], texcoords[i], trianglePalettes[i], White)
 	}
 
 	// Skip ahead to lights
 	f.seekPointer(f.PtrLightsAndBackground())
 
-	directionalLights := f.readDirectionalLights()
-	ambientLight := f.readAmbientLight()
-	background := f.readBackground()
+	mesh.directionalLights = f.readDirectionalLights()
+	mesh.ambientLight = f.readAmbientLight()
+	mesh.background = f.readBackground()
 
-	return Mesh{
-		triangles:         triangles,
-		ambientLight:      ambientLight,
-		directionalLights: directionalLights,
-		background:        background,
+	// Terrain is only present on battle maps.
+	terrainLen := int64(terrainHeaderLen + terrainLevels*terrainMaxTiles*terrainTileLen)
+	if ptr := f.PtrTerrain(); ptr != 0 && ptr+terrainLen <= int64(len(f.data)) {
+		f.seekPointer(ptr)
+		mesh.terrain = f.readTerrain()
 	}
-}
 
-func (r Reader) readGNSRecords(mapNum int) []GNSRecord {
-	sector := GNSSectors[mapNum]
-	r.seekSector(sector)
-
-	records := []GNSRecord{}
-	for {
-		record := make(GNSRecord, GNSRecordLen)
-		n, err := r.file.Read(record)
-		if err != nil || n != GNSRecordLen {
-			log.Fatalf("read gns record: %v", err)
-		}
-		if record.Type() == RecordTypeEnd {
-			break
-		}
-		records = append(records, record)
-	}
-	return records
+	return mesh, nil
 }
 
 type MeshFile struct {
@@ -351,21 +476,21 @@ func (r *MeshFile) readVertex() Vec3 {
 	return Vec3{x: x, y: -y, z: z}
 }
 
-func (r *MeshFile) readTriangle() Triangle {
+func (r *MeshFile) readTriangle() [3]Vec3 {
 	a := r.readVertex()
 	b := r.readVertex()
 	c := r.readVertex()
-	return Triangle{vertices: [3]Vec3{a, b, c}, color: White}
+	return [3]Vec3{a, b, c}
 }
 
-func (r *MeshFile) readQuad() []Triangle {
+func (r *MeshFile) readQuad() [][3]Vec3 {
 	a := r.readVertex()
 	b := r.readVertex()
 	c := r.readVertex()
 	d := r.readVertex()
-	return []Triangle{
-		{vertices: [3]Vec3{a, b, c}, color: White},
-		{vertices: [3]Vec3{b, d, c}, color: White},
+	return [][3]Vec3{
+		{a, b, c},
+		{b, d, c},
 	}
 }
 
@@ -516,10 +641,16 @@ func processTexCoords(uv Tex, page int) Tex {
 // so we split them here. The pixel values are just an index into a color palette so the
 // values are 0-15.
 func textureSplitPixels(buf []uint8) []Color {
-	data := make([]Color, 0)
-	for i := 0; i < textureRawLen; i++ {
-		colorA := uint8(buf[i] & 0x0F)
-		colorB := uint8((buf[i] & 0xF0) >> 4)
+	return splitPixels(buf[:textureRawLen])
+}
+
+// splitPixels splits 4-bit pixels into one palette index per pixel. The low
+// nibble is the left pixel.
+func splitPixels(buf []uint8) []Color {
+	data := make([]Color, 0, len(buf)*2)
+	for _, b := range buf {
+		colorA := uint8(b & 0x0F)
+		colorB := uint8((b & 0xF0) >> 4)
 
 		// We dont care about RGB here.
 		// This is just an index to the palette.
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testDirRecord returns an ISO 9660 directory record.
+func testDirRecord(name string, sector, size uint32, dir bool) []byte {
+	length := isoRecordMinLen + len(name)
+	if length%2 == 1 {
+		length++
+	}
+	record := make([]byte, length)
+	record[0] = byte(length)
+	binary.LittleEndian.PutUint32(record[2:6], sector)
+	binary.LittleEndian.PutUint32(record[10:14], size)
+	if dir {
+		record[25] = isoFlagDirectory
+	}
+	record[32] = byte(len(name))
+	copy(record[33:], name)
+	return record
+}
+
+// testImage returns an ISO image with 2048 byte sectors and a single file,
+// "DATA/TEST.BIN".
+func testImage(contents []byte) []byte {
+	sectors := make([]byte, 21*sectorSize)
+	sector := func(n int) []byte { return sectors[n*sectorSize : (n+1)*sectorSize] }
+
+	pvd := sector(isoPrimaryVolumeSector)
+	copy(pvd[1:6], "CD001")
+	copy(pvd[isoRootRecordOffset:], testDirRecord("\x00", 18, sectorSize, true))
+
+	root := append(testDirRecord("\x00", 18, sectorSize, true), testDirRecord("\x01", 18, sectorSize, true)...)
+	root = append(root, testDirRecord("DATA", 19, sectorSize, true)...)
+	copy(sector(18), root)
+
+	data := append(testDirRecord("\x00", 19, sectorSize, true), testDirRecord("\x01", 18, sectorSize, true)...)
+	data = append(data, testDirRecord("TEST.BIN;1", 20, uint32(len(contents)), false)...)
+	copy(sector(19), data)
+
+	copy(sector(20), contents)
+	return sectors
+}
+
+func TestNewReaderAt(t *testing.T) {
+	contents := []byte("heretic")
+	r, err := NewReaderAt(bytes.NewReader(testImage(contents)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.variant.name() != "PSX" || r.sectorRawSize != sectorSize {
+		t.Fatalf("got variant %s and sector size %d", r.variant.name(), r.sectorRawSize)
+	}
+
+	entry, err := r.findFile("data/test.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := r.readFile(entry.sector, entry.size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, contents) {
+		t.Errorf("got %q, want %q", data, contents)
+	}
+
+	if _, err := r.findFile("DATA/MISSING.BIN"); err == nil {
+		t.Error("found a missing file")
+	}
+	if _, err := r.readSector(100); err == nil {
+		t.Error("read a sector past the end of the image")
+	}
+}
+
+func TestNewReaderAtInvalid(t *testing.T) {
+	if _, err := NewReaderAt(bytes.NewReader(make([]byte, 100*sectorSize))); err == nil {
+		t.Error("read an image without a filesystem")
+	}
+
+	// The root directory points past the end of the image.
+	image := testImage(nil)
+	copy(image[isoPrimaryVolumeSector*sectorSize+isoRootRecordOffset:], testDirRecord("\x00", 1000, sectorSize, true))
+	r, err := NewReaderAt(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.findFile("DATA/TEST.BIN"); err == nil {
+		t.Error("read a directory past the end of the image")
+	}
+}
+
+// testGNS returns a GNS file with a texture and a primary mesh record.
+func testGNS(textureSector, meshSector uint16) []byte {
+	data := make([]byte, 3*GNSRecordLen)
+	for i, record := range []struct {
+		recordType RecordType
+		sector     uint16
+	}{{RecordTypeTexture, textureSector}, {RecordTypeMeshPrimary, meshSector}, {RecordTypeEnd, 0}} {
+		r := data[i*GNSRecordLen:]
+		binary.LittleEndian.PutUint16(r[4:6], uint16(record.recordType))
+		binary.LittleEndian.PutUint16(r[8:10], record.sector)
+		binary.LittleEndian.PutUint32(r[12:16], 100)
+	}
+	return data
+}
+
+// testPack returns an fftpack.bin archive of files.
+func testPack(files [][]byte) []byte {
+	pack := make([]byte, 8+4*len(files))
+	binary.LittleEndian.PutUint32(pack[0:4], uint32(len(files)))
+	for i, file := range files {
+		binary.LittleEndian.PutUint32(pack[8+4*i:], uint32(len(pack)))
+		pack = append(pack, file...)
+	}
+	return pack
+}
+
+// testPSPFiles returns the files of an archive with a GNS file, a mesh and a
+// texture for every map that exists on the PSX. The mesh has the lower PSX
+// sector, so it comes first.
+func testPSPFiles() [][]byte {
+	files := [][]byte{make([]byte, 5000)}
+	for n, sector := range GNSSectors {
+		if sector != 0 {
+			files = append(files, testGNS(uint16(900+n), uint16(800+n)), []byte("MESH"), []byte("TEXTURE"))
+		}
+	}
+	return files
+}
+
+// testPSPImage returns an ISO image with PSP_GAME/USRDIR/fftpack.bin.
+func testPSPImage(pack []byte) []byte {
+	image := make([]byte, 22*sectorSize)
+	sector := func(n int) []byte { return image[n*sectorSize : (n+1)*sectorSize] }
+
+	pvd := sector(isoPrimaryVolumeSector)
+	copy(pvd[1:6], "CD001")
+	copy(pvd[isoRootRecordOffset:], testDirRecord("\x00", 18, sectorSize, true))
+
+	dirs := []struct {
+		self, parent uint32
+		entry        []byte
+	}{
+		{18, 18, testDirRecord("PSP_GAME", 19, sectorSize, true)},
+		{19, 18, testDirRecord("USRDIR", 20, sectorSize, true)},
+		{20, 19, testDirRecord("fftpack.bin;1", 22, uint32(len(pack)), false)},
+	}
+	for _, dir := range dirs {
+		data := append(testDirRecord("\x00", dir.self, sectorSize, true), testDirRecord("\x01", dir.parent, sectorSize, true)...)
+		copy(sector(int(dir.self)), append(data, dir.entry...))
+	}
+	return append(image, pack...)
+}
+
+func TestNewReaderAtPSP(t *testing.T) {
+	r, err := NewReaderAt(bytes.NewReader(testPSPImage(testPack(testPSPFiles()))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.variant.name() != "PSP" {
+		t.Fatalf("got variant %s", r.variant.name())
+	}
+	if !r.HasMap(3) || r.HasMap(120) {
+		t.Error("wrong maps")
+	}
+
+	records, err := r.variant.readGNSRecords(*r, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Type() != RecordTypeTexture || records[1].Sector() != 803 {
+		t.Fatalf("got records %v", records)
+	}
+	for _, want := range []string{"TEXTURE", "MESH"} {
+		data, err := r.variant.readResource(*r, 3, records[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("got %q, want %q", data, want)
+		}
+		records = records[1:]
+	}
+
+	// The test files are too short to be a mesh.
+	if _, err := r.ReadMesh(3, 0); err == nil {
+		t.Error("read an invalid mesh")
+	}
+}
+
+func TestNewReaderAtInvalidPSP(t *testing.T) {
+	// A GNS file is missing.
+	files := testPSPFiles()
+	if _, err := NewReaderAt(bytes.NewReader(testPSPImage(testPack(files[:len(files)-3])))); err == nil {
+		t.Error("read an archive with a missing map")
+	}
+
+	// The offsets aren't in order.
+	pack := testPack(files)
+	binary.LittleEndian.PutUint32(pack[12:16], 8)
+	if _, err := NewReaderAt(bytes.NewReader(testPSPImage(pack))); err == nil {
+		t.Error("read an archive with invalid offsets")
+	}
+}
@@ -0,0 +1,106 @@
+// This file contains the ability to parse the terrain data of a map.
+//
+// The terrain describes the walkable tiles of a battle map. It is stored in the
+// mesh file at the terrain intra-file pointer. There are two levels of tiles,
+// the second is used for tiles above others like bridges and rooftops.
+//
+// Terrain layout:
+//
+//	byte  0:    Number of tiles on the x axis
+//	byte  1:    Number of tiles on the z axis
+//	bytes 2-:   256 tiles of 8 bytes for level 0 (row major by z)
+//	            256 tiles of 8 bytes for level 1
+//
+// Tile layout:
+//
+//	byte 0: Surface type (bits 0-5)
+//	byte 2: Height of the bottom of the tile
+//	byte 3: Slope height (bits 0-4), depth (bits 5-7)
+//	byte 4: Slope type
+//	byte 6: Flags (bit 0 cannot walk, bit 1 cannot select)
+package main
+
+const (
+	terrainTileLen   = 8
+	terrainMaxTiles  = 256
+	terrainLevels    = 2
+	terrainHeaderLen = 2
+
+	// Size of a tile in raw map units. A tile is 28 units wide and deep and
+	// each step of height is 12 units.
+	tileSize3D   = 28.0
+	tileHeight3D = 12.0
+)
+
+type Tile struct {
+	surface     uint8
+	height      int
+	slopeHeight int
+	depth       int
+	slopeType   uint8
+	noWalk      bool
+	noSelect    bool
+}
+
+type Terrain struct {
+	width, depth int
+	levels       [terrainLevels][]Tile
+}
+
+// readTerrain reads the terrain at the current offset.
+func (f *MeshFile) readTerrain() Terrain {
+	var terrain Terrain
+	terrain.width = int(f.readUint8())
+	terrain.depth = int(f.readUint8())
+
+	for level := 0; level < terrainLevels; level++ {
+		tiles := make([]Tile, terrainMaxTiles)
+		for i := range tiles {
+			tiles[i] = f.readTile()
+		}
+		terrain.levels[level] = tiles
+	}
+	return terrain
+}
+
+func (f *MeshFile) readTile() Tile {
+	data := f.data[f.offset : f.offset+terrainTileLen]
+	f.offset += terrainTileLen
+	return Tile{
+		surface:     data[0] & 0b111111,
+		height:      int(data[2]),
+		slopeHeight: int(data[3] & 0b11111),
+		depth:       int(data[3] >> 5),
+		slopeType:   data[4],
+		noWalk:      data[6]&0b1 != 0,
+		noSelect:    data[6]&0b10 != 0,
+	}
+}
+
+// Tile returns the tile at x, z on a level. It returns false if the position is
+// outside of the map.
+func (t Terrain) Tile(x, z, level int) (Tile, bool) {
+	if x < 0 || z < 0 || x >= t.width || z >= t.depth || level < 0 || level >= terrainLevels {
+		return Tile{}, false
+	}
+	index := z*t.width + x
+	if index >= len(t.levels[level]) {
+		return Tile{}, false
+	}
+	return t.levels[level][index], true
+}
+
+// TileCenter returns the center of the top of a tile in mesh coordinates. The
+// height is taken halfway up the slope.
+func (t Terrain) TileCenter(x, z, level int) (Vec3, bool) {
+	tile, ok := t.Tile(x, z, level)
+	if !ok {
+		return Vec3{}, false
+	}
+	y := (float64(tile.height) + float64(tile.slopeHeight)/2.0) * tileHeight3D
+	return Vec3{
+		x: (float64(x) + 0.5) * tileSize3D / 4096.0,
+		y: y / 4096.0,
+		z: (float64(z) + 0.5) * tileSize3D / 4096.0,
+	}, true
+}
@@ -0,0 +1,86 @@
+// This file contains the free-fly camera mode.
+//
+// In fly mode the camera moves like in a first person game. The fly actions
+// move it while they are held: by default W/A/S/D move forward, left, back and
+// right, Space and Ctrl move up and down, and a controller's right stick moves
+// too. The Orbit input looks around instead of orbiting the map. Holding Shift
+// moves faster and Alt moves slower. Fly mode switches to the perspective
+// projection because moving closer doesn't change an orthographic view.
+package main
+
+import (
+	"math"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	flySpeed     = 1.0  // World units per second
+	flyFastSpeed = 4.0  // Multiplier while Shift is held
+	flySlowSpeed = 0.25 // Multiplier while Alt is held
+)
+
+// flyDirections are the directions of the fly actions in camera space: x is
+// right, y is up and z is forward.
+var flyDirections = map[Action]Vec3{
+	ActionFlyForward: {0, 0, 1},
+	ActionFlyBack:    {0, 0, -1},
+	ActionFlyLeft:    {-1, 0, 0},
+	ActionFlyRight:   {1, 0, 0},
+	ActionFlyUp:      {0, 1, 0},
+	ActionFlyDown:    {0, -1, 0},
+}
+
+func (e *Engine) toggleFly() {
+	e.flyMode = !e.flyMode
+	if e.flyMode {
+		e.gameMode = false
+	}
+	if e.flyMode && e.camera.projection != Perspective {
+		e.camera.toggleProjection()
+	}
+}
+
+// updateFly moves the camera with the fly inputs that are held.
+func (e *Engine) updateFly() {
+	var move Vec3
+	for action, dir := range flyDirections {
+		if e.held(action) {
+			move = move.Add(dir)
+		}
+	}
+	if in, ok := e.boundInput(ActionFlyMove, inputControllerStick); ok {
+		for _, controller := range e.controllers {
+			// Pushing the stick up moves forward.
+			x, y := controllerStick(controller, in.code)
+			move = move.Add(Vec3{x, 0, -y})
+		}
+	}
+
+	// Keys move at full speed and the stick moves slower when it is tilted
+	// less.
+	speed := flySpeed * e.delta * math.Min(move.Length(), 1)
+	mod := sdl.GetModState()
+	if mod&sdl.KMOD_SHIFT != 0 {
+		speed *= flyFastSpeed
+	}
+	if mod&sdl.KMOD_ALT != 0 {
+		speed *= flySlowSpeed
+	}
+
+	// Up and down are along the world up, not the camera up.
+	offset := e.camera.right().Mul(move.x).
+		Add(e.camera.up.Mul(move.y)).
+		Add(e.camera.forward().Mul(move.z))
+	if offset.Length() == 0 {
+		// No inputs, or opposite inputs.
+		return
+	}
+	e.camera.Move(offset.Normalize().Mul(speed))
+}
+
+// flyHelp returns the help text of fly mode with the bound keys.
+func (e *Engine) flyHelp() string {
+	return "Fly: " + e.keysLabel("Move", ActionFlyForward, ActionFlyLeft, ActionFlyBack, ActionFlyRight) + " " +
+		e.keysLabel("Up/Down", ActionFlyUp, ActionFlyDown) + " [Shift] Fast [Alt] Slow " + e.keyLabel(ActionToggleFly, "Done")
+}
@@ -0,0 +1,148 @@
+// This file contains the game camera mode.
+//
+// Final Fantasy Tactics shows battles with an orthographic camera that looks
+// at the map diagonally from one of four quadrants, at a high or a low tilt.
+// It turns in 90 degree steps. The game camera mode uses the same angles, so
+// screenshots from the viewer line up with gameplay captures. Q and E turn to
+// the next quadrant and R toggles the tilt. Panning and zooming still work but
+// orbiting doesn't, because it would leave the fixed angles. Changing the
+// projection leaves the mode.
+//
+// The tilts are approximate. In the low tilt a flat tile is drawn twice as wide
+// as it is tall, like in the game.
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	gameTiltLow         = math.Pi / 6 // 30 degrees
+	gameTiltHigh        = math.Pi / 4 // 45 degrees
+	gameCameraQuadrants = 4
+	gameCameraDuration  = 0.25 // Seconds to turn or tilt
+)
+
+// gameAzimuth returns the azimuth of a quadrant. Quadrant 0 is the default
+// camera angle, looking at the map from the eye at (1, 1, -1).
+func gameAzimuth(quadrant int) float64 {
+	return 3*math.Pi/4 + float64(quadrant)*math.Pi/2
+}
+
+func gameTilt(high bool) float64 {
+	if high {
+		return gameTiltHigh
+	}
+	return gameTiltLow
+}
+
+// gameEyeOffset returns the offset of the eye from front for a quadrant and
+// tilt.
+func gameEyeOffset(quadrant int, high bool, distance float64) Vec3 {
+	return orbitOffset(gameAzimuth(quadrant), gameTilt(high), distance)
+}
+
+// gameCamera is the state of the game camera mode. The camera is animated from
+// the angles at the start of a turn to the angles of the quadrant and tilt.
+type gameCamera struct {
+	quadrant int // 0 to 3
+	high     bool
+
+	// Animation
+	fromAzimuth, fromTilt float64
+	toAzimuth             float64 // Not wrapped, so turns take the short way.
+	progress              float64 // 0 to 1
+}
+
+func (e *Engine) toggleGameCamera() {
+	if e.gameMode {
+		e.gameMode = false
+		return
+	}
+	// Turn to the nearest quadrant.
+	azimuth, _ := e.camera.Angles()
+	quadrant := math.Round((azimuth - gameAzimuth(0)) / (math.Pi / 2))
+	e.startGameCamera(int(quadrant), e.game.high)
+}
+
+// startGameCamera starts the game camera mode and turns to a quadrant and
+// tilt.
+func (e *Engine) startGameCamera(quadrant int, high bool) {
+	e.gameMode = true
+	e.flyMode = false
+	e.camera.Stop()
+	if e.camera.projection != Orthographic {
+		e.camera.toggleProjection()
+	}
+	e.game.quadrant = (quadrant%gameCameraQuadrants + gameCameraQuadrants) % gameCameraQuadrants
+	e.game.high = high
+	e.game.toAzimuth = gameAzimuth(quadrant)
+	e.animateGameCamera()
+}
+
+// turnGameCamera turns the camera by a number of quadrants. If the game camera
+// mode isn't on, it only starts it at the nearest quadrant.
+func (e *Engine) turnGameCamera(step int) {
+	if !e.gameMode {
+		e.toggleGameCamera()
+		return
+	}
+	e.game.quadrant = ((e.game.quadrant+step)%gameCameraQuadrants + gameCameraQuadrants) % gameCameraQuadrants
+	e.game.toAzimuth += float64(step) * math.Pi / 2
+	e.animateGameCamera()
+}
+
+// toggleGameTilt switches between the high and low tilt. If the game camera
+// mode isn't on, it only starts it.
+func (e *Engine) toggleGameTilt() {
+	if !e.gameMode {
+		e.toggleGameCamera()
+		return
+	}
+	e.game.high = !e.game.high
+	e.animateGameCamera()
+}
+
+// animateGameCamera starts animating from the current angles.
+func (e *Engine) animateGameCamera() {
+	azimuth, tilt := e.camera.Angles()
+	// Keep the start within half a turn of the target.
+	for azimuth-e.game.toAzimuth > math.Pi {
+		azimuth -= 2 * math.Pi
+	}
+	for e.game.toAzimuth-azimuth > math.Pi {
+		azimuth += 2 * math.Pi
+	}
+	e.game.fromAzimuth, e.game.fromTilt = azimuth, tilt
+	e.game.progress = 0
+}
+
+// updateGameCamera moves the camera toward the angles of the quadrant and tilt.
+// The angles are set during camera transitions too, which keep the angles
+// they started with.
+func (e *Engine) updateGameCamera() {
+	if e.game.progress >= 1 && e.camera.transition == nil {
+		return
+	}
+	e.game.progress = math.Min(e.game.progress+e.delta/gameCameraDuration, 1)
+
+	// Ease in and out.
+	t := e.game.progress
+	t = t * t * (3 - 2*t)
+	azimuth := e.game.fromAzimuth + (e.game.toAzimuth-e.game.fromAzimuth)*t
+	tilt := e.game.fromTilt + (gameTilt(e.game.high)-e.game.fromTilt)*t
+	e.camera.SetAngles(azimuth, tilt)
+}
+
+// gameCameraText returns the help text of the game camera, like "2/4 High".
+func (e *Engine) gameCameraText() string {
+	if !e.gameMode {
+		return "Off"
+	}
+	tilt := "Low"
+	if e.game.high {
+		tilt = "High"
+	}
+	return fmt.Sprintf("%d/%d %s", e.game.quadrant+1, gameCameraQuadrants, tilt)
+}
@@ -0,0 +1,139 @@
+// This file contains the image viewer.
+//
+// The image viewer pages through the TIM images on the disc. Indexed images
+// can be viewed with each of their CLUTs and any image can be exported as a
+// PNG to the exports directory.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const exportsDir = "exports"
+
+// timFiles returns the paths of all TIM files on the disc.
+func (r Reader) timFiles() ([]string, error) {
+	root, err := r.rootDir()
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	err = r.walkDir(root, "", func(path string, entry isoEntry) {
+		if !entry.dir && strings.HasSuffix(strings.ToUpper(entry.name), ".TIM") {
+			files = append(files, path)
+		}
+	})
+	return files, err
+}
+
+// toggleImageMode switches between the map and the image viewer. The list of
+// images is read the first time the viewer is opened.
+func (e *Engine) toggleImageMode() {
+	e.imageMode = !e.imageMode
+	if e.imageMode && e.images == nil {
+		images, err := e.reader.timFiles()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "list images:", err)
+			e.imageMode = false
+			return
+		}
+		e.images = images
+		e.setImage(0)
+	}
+}
+
+// setImage loads the image at index. Images that fail to decode are kept in
+// the list so the index still matches, but nothing is drawn.
+func (e *Engine) setImage(index int) {
+	if len(e.images) == 0 {
+		return
+	}
+	e.imageIndex = (index + len(e.images)) % len(e.images)
+	e.imageClut = 0
+
+	tim, err := e.reader.ReadTIM(e.images[e.imageIndex])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load image:", err)
+	}
+	e.image = tim
+}
+
+// cycleClut selects the next or previous CLUT of an indexed image.
+func (e *Engine) cycleClut(step int) {
+	if n := len(e.image.cluts); n > 0 && e.image.indexed() {
+		e.imageClut = (e.imageClut + step + n) % n
+	}
+}
+
+// exportImage writes the current image as a PNG. Indexed images are written
+// with the current CLUT, which is added to the file name.
+func (e *Engine) exportImage() {
+	if e.image.texture.data == nil {
+		return
+	}
+	name := strings.TrimSuffix(path.Base(e.images[e.imageIndex]), path.Ext(e.images[e.imageIndex]))
+	if e.image.indexed() {
+		name += fmt.Sprintf("_clut%d", e.imageClut)
+	}
+	file := filepath.Join(exportsDir, name+".png")
+
+	if err := os.MkdirAll(exportsDir, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, "export image:", err)
+		return
+	}
+	if err := writePNG(file, e.image.Texture(e.imageClut).Image()); err != nil {
+		fmt.Fprintln(os.Stderr, "export image:", err)
+		return
+	}
+	fmt.Println("exported", file)
+}
+
+// handleImageKey handles keys in the image viewer. It returns true if the key
+// was used.
+func (e *Engine) handleImageKey(key sdl.Keycode) bool {
+	switch key {
+	case sdl.K_LEFT:
+		e.setImage(e.imageIndex - 1)
+	case sdl.K_RIGHT:
+		e.setImage(e.imageIndex + 1)
+	case sdl.K_UP:
+		e.cycleClut(1)
+	case sdl.K_DOWN:
+		e.cycleClut(-1)
+	case sdl.K_x:
+		e.exportImage()
+	default:
+		return false
+	}
+	return true
+}
+
+// renderImage draws the current image and its info instead of the map.
+func (e *Engine) renderImage() {
+	if e.image.texture.data != nil {
+		e.renderer.DrawImage(e.image.Texture(e.imageClut))
+	}
+
+	info := "No TIM images found"
+	if len(e.images) > 0 {
+		t := e.image.texture
+		info = fmt.Sprintf("%d/%d %s  %dx%d %dbpp", e.imageIndex+1, len(e.images), e.images[e.imageIndex], t.width, t.height, e.image.bpp)
+		if n := len(e.image.cluts); n > 0 && e.image.indexed() {
+			info += fmt.Sprintf("  CLUT %d/%d", e.imageClut+1, n)
+		}
+	}
+	e.window.SetText(10, e.window.height-50, info, White)
+	help := "[Left/Right] Image "
+	if e.image.indexed() && len(e.image.cluts) > 1 {
+		help += "[Up/Down] CLUT "
+	}
+	help += "[X] Export PNG " + e.keyLabel(ActionImages, "Done")
+	e.window.SetText(10, e.window.height-25, help, White)
+	e.window.Present()
+}
@@ -0,0 +1,159 @@
+// This file contains the ability to read the ISO 9660 directory tree of the bin
+// file.
+//
+// Most of the data we need (maps) are referenced by sector from the GNS
+// records. Other files, like unit sprites, are looked up by name in the
+// directory tree instead.
+//
+// The primary volume descriptor is always at sector 16. It contains the
+// directory record of the root directory. Each directory is a list of
+// directory records which never cross a sector boundary. A record length of
+// zero means the rest of the sector is padding.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	isoPrimaryVolumeSector = 16
+	isoRootRecordOffset    = 156
+	isoFlagDirectory       = 0b10
+	isoRecordMinLen        = 33 // Without the name
+)
+
+// isoEntry is a file or directory from the directory tree.
+type isoEntry struct {
+	name   string
+	sector int64
+	size   int64
+	dir    bool
+}
+
+// parseDirRecord parses a single directory record. The version suffix (";1")
+// is removed from file names.
+func parseDirRecord(data []byte) (isoEntry, error) {
+	if len(data) < isoRecordMinLen || isoRecordMinLen+int(data[32]) > len(data) {
+		return isoEntry{}, errors.New("invalid ISO 9660 directory record")
+	}
+	nameLen := int(data[32])
+	name := string(data[33 : 33+nameLen])
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	return isoEntry{
+		name:   name,
+		sector: int64(binary.LittleEndian.Uint32(data[2:6])),
+		size:   int64(binary.LittleEndian.Uint32(data[10:14])),
+		dir:    data[25]&isoFlagDirectory != 0,
+	}, nil
+}
+
+// rootDir returns the root directory from the primary volume descriptor.
+func (r Reader) rootDir() (isoEntry, error) {
+	pvd, err := r.readSector(isoPrimaryVolumeSector)
+	if err != nil {
+		return isoEntry{}, err
+	}
+	if string(pvd[1:6]) != "CD001" {
+		return isoEntry{}, errors.New("missing ISO 9660 primary volume descriptor")
+	}
+	root, err := parseDirRecord(pvd[isoRootRecordOffset:])
+	if err != nil {
+		return isoEntry{}, err
+	}
+	root.name = ""
+	return root, nil
+}
+
+// readDir returns the entries of a directory. The "." and ".." entries are
+// skipped.
+func (r Reader) readDir(dir isoEntry) ([]isoEntry, error) {
+	data, err := r.readFile(dir.sector, dir.size)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []isoEntry{}
+	for offset := 0; offset < len(data); {
+		length := int(data[offset])
+		if length == 0 {
+			// Skip the padding to the next sector.
+			offset = (offset/sectorSize + 1) * sectorSize
+			continue
+		}
+
+		if offset+length > len(data) {
+			return nil, errors.New("invalid ISO 9660 directory record")
+		}
+		record := data[offset : offset+length]
+		offset += length
+
+		entry, err := parseDirRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		// The "." and ".." entries have the single byte names 0x00 and 0x01.
+		if record[32] == 1 && (record[33] == 0 || record[33] == 1) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// findFile returns the entry for a slash separated path, like
+// "BATTLE/RAMUZA.SPR". The lookup is case insensitive.
+func (r Reader) findFile(path string) (isoEntry, error) {
+	entry, err := r.rootDir()
+	if err != nil {
+		return isoEntry{}, err
+	}
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		if !entry.dir {
+			return isoEntry{}, fmt.Errorf("%s: not a directory", entry.name)
+		}
+
+		children, err := r.readDir(entry)
+		if err != nil {
+			return isoEntry{}, err
+		}
+		found := false
+		for _, child := range children {
+			if strings.EqualFold(child.name, part) {
+				entry = child
+				found = true
+				break
+			}
+		}
+		if !found {
+			return isoEntry{}, fmt.Errorf("%s: file not found", path)
+		}
+	}
+	return entry, nil
+}
+
+// walkDir calls fn for every file and directory below dir, parents before
+// children, with its slash separated path.
+func (r Reader) walkDir(dir isoEntry, path string, fn func(path string, entry isoEntry)) error {
+	entries, err := r.readDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		p := entry.name
+		if path != "" {
+			p = path + "/" + entry.name
+		}
+		fn(p, entry)
+		if entry.dir {
+			if err := r.walkDir(entry, p, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
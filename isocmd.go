@@ -0,0 +1,176 @@
+// This file contains the ls and extract commands for browsing the directory
+// tree of the bin file.
+//
+//	go run *.go ls <path-to-bin> [dir]
+//	go run *.go extract [-o dir] <path-to-bin> <pattern>...
+//
+// Patterns are slash separated paths matched with path.Match, like
+// "BATTLE/*.SPR" or "MAP/MAP049.*". A pattern matching a directory extracts
+// everything below it. Matching is case insensitive.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// runISOCommand runs the ls or extract command with its arguments.
+func runISOCommand(name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	out := fs.String("o", ".", "output directory for extracted files")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go run *.go ls <path-to-bin> [dir]")
+		fmt.Fprintln(os.Stderr, "       go run *.go extract [-o dir] <path-to-bin> <pattern>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	reader, err := NewReader(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	switch name {
+	case "ls":
+		dir := ""
+		if fs.NArg() > 1 {
+			dir = fs.Arg(1)
+		}
+		return listFiles(os.Stdout, reader, dir)
+	case "extract":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return errUsage
+		}
+		return extractFiles(reader, fs.Args()[1:], *out)
+	}
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// listFiles prints the LBA, size and path of every entry below dir.
+func listFiles(w io.Writer, r *Reader, dir string) error {
+	entry, err := r.rootDir()
+	if err != nil {
+		return err
+	}
+	if dir != "" {
+		if entry, err = r.findFile(dir); err != nil {
+			return err
+		}
+		if !entry.dir {
+			printEntry(w, dir, entry)
+			return nil
+		}
+	}
+
+	fmt.Fprintf(w, "%8s %10s  %s\n", "LBA", "SIZE", "PATH")
+	return r.walkDir(entry, strings.Trim(dir, "/"), func(path string, entry isoEntry) {
+		printEntry(w, path, entry)
+	})
+}
+
+func printEntry(w io.Writer, path string, entry isoEntry) {
+	if entry.dir {
+		path += "/"
+	}
+	fmt.Fprintf(w, "%8d %10d  %s\n", entry.sector, entry.size, path)
+}
+
+// extractFiles writes every file matching one of the patterns below out,
+// keeping the directory structure of the disc.
+func extractFiles(r *Reader, patterns []string, out string) error {
+	for i, pattern := range patterns {
+		pattern = strings.ToUpper(strings.Trim(pattern, "/"))
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: %w", patterns[i], err)
+		}
+		patterns[i] = pattern
+	}
+
+	// Directories that matched a pattern. Everything below them is
+	// extracted.
+	var matchedDirs []string
+	matches := func(p string) bool {
+		upper := strings.ToUpper(p)
+		for _, dir := range matchedDirs {
+			if strings.HasPrefix(upper, dir+"/") {
+				return true
+			}
+		}
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, upper); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	root, err := r.rootDir()
+	if err != nil {
+		return err
+	}
+	var count int
+	walkErr := r.walkDir(root, "", func(p string, entry isoEntry) {
+		if err != nil || !matches(p) {
+			return
+		}
+		if entry.dir {
+			matchedDirs = append(matchedDirs, strings.ToUpper(p))
+			return
+		}
+		if err = extractFile(r, entry, filepath.Join(out, filepath.FromSlash(p))); err == nil {
+			fmt.Println(p)
+			count++
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if walkErr != nil {
+		return walkErr
+	}
+	if count == 0 {
+		return errors.New("no files match")
+	}
+	return nil
+}
+
+// extractFile writes the user data of a file to dest. The file is copied a
+// sector at a time so large files like movies don't need to fit in memory.
+func extractFile(r *Reader, entry isoEntry, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for remaining, sector := entry.size, entry.sector; remaining > 0; sector++ {
+		data, err := r.readSector(sector)
+		if err != nil {
+			return err
+		}
+		if remaining < sectorSize {
+			data = data[:remaining]
+		}
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+		remaining -= int64(len(data))
+	}
+	return f.Close()
+}
@@ -0,0 +1,113 @@
+// This file contains the background map loader.
+//
+// Reading and parsing a map takes long enough to freeze the window, so maps are
+// loaded on their own goroutine. Every finished load is sent to the results
+// channel, which the engine drains each frame. The engine only switches to a
+// loaded map if it is still the one it is waiting for, so prefetched maps and
+// thumbnails share the same path.
+//
+// Parsed meshes, including their textures, are kept in a least recently used
+// cache bounded by their approximate size in memory. Each scenario of a map is
+// a separate mesh. Maps that fail to load are remembered so they aren't
+// prefetched again, but a map the user asks for is always read again.
+package main
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// maxMeshCacheBytes bounds the mesh cache. A map is usually 1-2 MB, mostly
+// its texture.
+const maxMeshCacheBytes = 64 << 20
+
+// mapKey identifies a map in one of its scenarios.
+type mapKey struct {
+	mapNum   int
+	scenario int
+}
+
+type loadedMap struct {
+	mapKey
+	mesh Mesh
+	err  error
+}
+
+type mapLoader struct {
+	reader  *Reader
+	results chan loadedMap
+
+	mu       sync.Mutex
+	cache    *lruCache[mapKey, Mesh]
+	inflight map[mapKey]bool
+	failed   map[mapKey]error
+}
+
+func newMapLoader(reader *Reader) *mapLoader {
+	return &mapLoader{
+		reader:   reader,
+		results:  make(chan loadedMap, 16),
+		cache:    newLRUCache[mapKey, Mesh](maxMeshCacheBytes),
+		inflight: make(map[mapKey]bool),
+		failed:   make(map[mapKey]error),
+	}
+}
+
+// cached returns a map if it is in the cache.
+func (l *mapLoader) cached(mapNum, scenario int) (Mesh, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cache.get(mapKey{mapNum, scenario})
+}
+
+// err returns the error of a map that failed to load.
+func (l *mapLoader) err(mapNum, scenario int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.failed[mapKey{mapNum, scenario}]
+}
+
+// forget drops the error of a map that failed to load, so the next load reads
+// it again.
+func (l *mapLoader) forget(mapNum, scenario int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failed, mapKey{mapNum, scenario})
+}
+
+// load starts loading a map in the background. Maps that are cached or already
+// loading are not loaded again, and neither are maps that failed. See forget.
+func (l *mapLoader) load(mapNum, scenario int) {
+	key := mapKey{mapNum, scenario}
+	l.mu.Lock()
+	if l.inflight[key] || l.cache.contains(key) || l.failed[key] != nil {
+		l.mu.Unlock()
+		return
+	}
+	l.inflight[key] = true
+	l.mu.Unlock()
+
+	go func() {
+		mesh, err := l.reader.ReadMesh(mapNum, scenario)
+
+		l.mu.Lock()
+		if err != nil {
+			l.failed[key] = err
+		} else {
+			l.cache.add(key, mesh, meshSize(mesh))
+		}
+		delete(l.inflight, key)
+		l.mu.Unlock()
+
+		l.results <- loadedMap{key, mesh, err}
+	}()
+}
+
+// meshSize returns the approximate size of a mesh in memory.
+func meshSize(m Mesh) int {
+	return len(m.vertices)*int(unsafe.Sizeof(Vec3{})) +
+		len(m.normals)*int(unsafe.Sizeof(Vec3{})) +
+		len(m.texcoords)*int(unsafe.Sizeof(Tex{})) +
+		len(m.faces)*int(unsafe.Sizeof(Face{})) +
+		len(m.texture.data)*int(unsafe.Sizeof(Color{}))
+}
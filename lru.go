@@ -0,0 +1,65 @@
+package main
+
+import "container/list"
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int
+}
+
+// lruCache is a least recently used cache bounded by the total size of its
+// values. It is not safe for concurrent use.
+type lruCache[K comparable, V any] struct {
+	maxSize int
+	size    int
+	order   *list.List // Front is the most recently used.
+	entries map[K]*list.Element
+}
+
+func newLRUCache[K comparable, V any](maxSize int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[K]*list.Element),
+	}
+}
+
+func (c *lruCache[K, V]) contains(key K) bool {
+	_, ok := c.entries[key]
+	return ok
+}
+
+// get returns a value and marks it as the most recently used.
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry[K, V]).value, true
+}
+
+// add adds a value and evicts the least recently used values until the cache
+// fits. The newest value is always kept, even if it is larger than the cache.
+func (c *lruCache[K, V]) add(key K, value V, size int) {
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	entry := &lruEntry[K, V]{key: key, value: value, size: size}
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += entry.size
+
+	for c.size > c.maxSize && c.order.Len() > 1 {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *lruCache[K, V]) remove(element *list.Element) {
+	entry := element.Value.(*lruEntry[K, V])
+	c.order.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
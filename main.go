@@ -1,10 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"os/user"
-	"path/filepath"
 )
 
 const (
@@ -12,50 +11,35 @@ const (
 	windowHeight = 768
 )
 
-func main() {
-
-	window := NewWindow(windowWidth, windowHeight)
-	defer window.Close()
-	renderer := NewRenderer(window)
-
-	path, err := getPath()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		fmt.Fprintln(os.Stderr, "Usage: go run *.go <path-to-bin>")
-		os.Exit(1)
-	}
-
-	reader := NewReader(path)
-	defer reader.Close()
-
-	engine := NewEngine(window, renderer, reader)
-	engine.setMap(49)
-
-	engine.setup()
-	for engine.isRunning {
-		engine.processInput()
-		engine.update()
-		engine.render()
-	}
+// errUsage is returned by a command after it printed its usage.
+var errUsage = errors.New("invalid arguments")
+
+// commands are the subcommands of heretic. Without a known subcommand the
+// arguments are passed to view, so "go run *.go <path-to-bin>" still works.
+var commands = map[string]func(args []string) error{
+	"view":    runView,
+	"render":  runRender,
+	"export":  runExportCommand,
+	"info":    runInfoCommand,
+	"maps":    runMapsCommand,
+	"ls":      func(args []string) error { return runISOCommand("ls", args) },
+	"extract": func(args []string) error { return runISOCommand("extract", args) },
 }
 
-func getPath() (string, error) {
-	var path string
-	if len(os.Args) > 1 {
-		// User specified path
-		path = os.Args[1]
-	} else {
-		// Use default path
-		usr, err := user.Current()
-		if err != nil {
-			return "", err
+func main() {
+	name, args := "view", os.Args[1:]
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			name, args = args[0], args[1:]
 		}
-		path = filepath.Join(usr.HomeDir, "media", "emu", "fft.bin")
 	}
 
-	if _, err := os.Stat(path); err != nil {
-		return "", err
+	if err := commands[name](args); err != nil {
+		if errors.Is(err, errUsage) {
+			// Like the flag package.
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
-	return path, nil
 }
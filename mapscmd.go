@@ -0,0 +1,90 @@
+// This file contains the maps command for searching the map catalog and the
+// info command for describing the disc or a map.
+//
+//	go run *.go maps <path-to-bin> [query]
+//	go run *.go info <path-to-bin> [map]
+//
+// Without a query every map is listed. See SearchMaps for the query words.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func runMapsCommand(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: go run *.go maps <path-to-bin> [query]")
+		return errUsage
+	}
+
+	reader, err := NewReader(args[0])
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	catalog, err := reader.ReadCatalog()
+	if err != nil {
+		return err
+	}
+	maps := SearchMaps(catalog, strings.Join(args[1:], " "))
+	for _, m := range maps {
+		fmt.Println(m)
+	}
+	if len(maps) == 0 {
+		return fmt.Errorf("no maps match %q", strings.Join(args[1:], " "))
+	}
+	return nil
+}
+
+// runInfoCommand prints the release and layout of the disc, or the details of
+// a map with its numbered scenarios.
+func runInfoCommand(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: go run *.go info <path-to-bin> [map]")
+		return errUsage
+	}
+
+	reader, err := NewReader(args[0])
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if len(args) == 1 {
+		maps := 0
+		for n := firstMap; n <= lastMap; n++ {
+			if reader.HasMap(n) {
+				maps++
+			}
+		}
+		fmt.Printf("Release:  %s\n", reader.variant.name())
+		fmt.Printf("Sectors:  %d bytes, %d byte header\n", reader.sectorRawSize, reader.sectorHeaderSize)
+		fmt.Printf("Maps:     %d\n", maps)
+		return nil
+	}
+
+	n, err := strconv.Atoi(args[1])
+	if err != nil || !reader.HasMap(n) {
+		return fmt.Errorf("map %s is not on the disc", args[1])
+	}
+	info, err := reader.ReadMapInfo(n)
+	if err != nil {
+		return err
+	}
+	kind := "event"
+	if info.battle {
+		kind = "battle"
+	}
+	fmt.Printf("Map:      %d %s\n", info.number, info.name)
+	fmt.Printf("Area:     %s\n", info.area)
+	fmt.Printf("Kind:     %s\n", kind)
+	fmt.Printf("Polygons: %d triangles, %d quads\n", info.triangles, info.quads)
+	for i, s := range info.scenarios {
+		fmt.Printf("Scenario: %d %s\n", i, s)
+	}
+	return nil
+}
@@ -284,6 +284,54 @@ func LookAt(eye, target, up Vec3) Matrix {
 	}
 }
 
+//
+// Bounds
+//
+
+// AABB is an axis aligned bounding box.
+type AABB struct {
+	min, max Vec3
+}
+
+func (b AABB) Center() Vec3 {
+	return b.min.Add(b.max).Mul(0.5)
+}
+
+// Transform returns the corners of the box transformed by a matrix. They are
+// no longer axis aligned if the matrix rotates.
+func (b AABB) Transform(m Matrix) [8]Vec3 {
+	corners := b.Corners()
+	for i := range corners {
+		corners[i] = m.MulVec3(corners[i])
+	}
+	return corners
+}
+
+func (b AABB) Corners() [8]Vec3 {
+	return [8]Vec3{
+		{b.min.x, b.min.y, b.min.z},
+		{b.max.x, b.min.y, b.min.z},
+		{b.min.x, b.max.y, b.min.z},
+		{b.max.x, b.max.y, b.min.z},
+		{b.min.x, b.min.y, b.max.z},
+		{b.max.x, b.min.y, b.max.z},
+		{b.min.x, b.max.y, b.max.z},
+		{b.max.x, b.max.y, b.max.z},
+	}
+}
+
+// Sphere is a bounding sphere.
+type Sphere struct {
+	center Vec3
+	radius float64
+}
+
+// Transform returns the sphere transformed by a matrix with a uniform scale.
+func (s Sphere) Transform(m Matrix) Sphere {
+	scale := m.MulVec3(Vec3{1, 0, 0}).Sub(m.MulVec3(Vec3{})).Length()
+	return Sphere{m.MulVec3(s.center), s.radius * scale}
+}
+
 //
 // Misc utility functions
 //
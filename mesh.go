@@ -6,23 +6,42 @@ type Tex struct {
 	u, v float64
 }
 
+// Face is a triangle of the mesh. Each corner indexes into the mesh vertices,
+// normals and texcoords so shared data is stored and transformed only once.
+type Face struct {
+	vertices  [3]int
+	normals   [3]int
+	texcoords [3]int
+	palette   Palette
+
+	// Color of untextured face
+	color Color
+}
+
+// Triangle is a face after it has been transformed, lit and projected to the
+// screen. These are built every frame for the renderer.
 type Triangle struct {
-	vertices  [3]Vec3
-	normals   [3]Vec3
 	texcoords [3]Tex
 	palette   Palette
+	texture   *Texture
 
 	// Computed during render
-	points     [3]Vec2
-	avgDepth   float64
-	lightColor Color
+	points      [3]Vec2
+	depths      [3]float64 // For the depthbuffer. See depthValue().
+	avgDepth    float64
+	lightColor  Color    // Flat shading
+	lightColors [3]Color // Per vertex for gouraud shading
 
 	// Color of untextured triangle
 	color Color
 }
 
 type Mesh struct {
-	triangles   []Triangle
+	vertices  []Vec3
+	normals   []Vec3
+	texcoords []Tex
+	faces     []Face
+
 	texture     Texture
 	scale       Vec3
 	rotation    Vec3
@@ -31,37 +50,105 @@ type Mesh struct {
 	ambientLight      AmbientLight
 	directionalLights []DirectionalLight
 	background        Background
+	terrain           Terrain
 }
 
 func NewMesh() Mesh {
 	return Mesh{scale: Vec3{1, 1, 1}}
 }
 
-// centerTranslation returns a translation vector that will center the mesh.
-func (m *Mesh) coordCenter() Vec3 {
-	var minx float64 = math.MaxInt16
-	var maxx float64 = math.MinInt16
-	var miny float64 = math.MaxInt16
-	var maxy float64 = math.MinInt16
-	var minz float64 = math.MaxInt16
-	var maxz float64 = math.MinInt16
-
-	for _, t := range m.triangles {
-		for i := 0; i < 3; i++ {
-			// Min
-			minx = math.Min(t.vertices[i].x, minx)
-			miny = math.Min(t.vertices[i].y, miny)
-			minz = math.Min(t.vertices[i].z, minz)
-			// Max
-			maxx = math.Max(t.vertices[i].x, maxx)
-			maxy = math.Max(t.vertices[i].y, maxy)
-			maxz = math.Max(t.vertices[i].z, maxz)
-		}
+// meshBuilder adds faces to a mesh while deduplicating the vertices, normals and
+// texcoords. Loaders describe each face by value and the builder takes care of
+// the indexing.
+type meshBuilder struct {
+	mesh      *Mesh
+	vertices  map[Vec3]int
+	normals   map[Vec3]int
+	texcoords map[Tex]int
+}
+
+func newMeshBuilder(mesh *Mesh) *meshBuilder {
+	return &meshBuilder{
+		mesh:      mesh,
+		vertices:  make(map[Vec3]int),
+		normals:   make(map[Vec3]int),
+		texcoords: make(map[Tex]int),
+	}
+}
+
+// addFace adds a face to the mesh. A zero normal means the face has no vertex
+// normals and the face normal will be used for lighting.
+func (b *meshBuilder) addFace(vertices [3]Vec3, normals [3]Vec3, texcoords [3]Tex, palette Palette, color Color) {
+	face := Face{palette: palette, color: color}
+	for i := 0; i < 3; i++ {
+		face.vertices[i] = b.vertex(vertices[i])
+		face.normals[i] = b.normal(normals[i])
+		face.texcoords[i] = b.texcoord(texcoords[i])
 	}
+	b.mesh.faces = append(b.mesh.faces, face)
+}
+
+func (b *meshBuilder) vertex(v Vec3) int {
+	if i, ok := b.vertices[v]; ok {
+		return i
+	}
+	i := len(b.mesh.vertices)
+	b.mesh.vertices = append(b.mesh.vertices, v)
+	b.vertices[v] = i
+	return i
+}
 
-	x := (maxx + minx) / 2.0
-	y := (maxy + miny) / 2.0
-	z := (maxz + minz) / 2.0
+func (b *meshBuilder) normal(n Vec3) int {
+	if i, ok := b.normals[n]; ok {
+		return i
+	}
+	i := len(b.mesh.normals)
+	b.mesh.normals = append(b.mesh.normals, n)
+	b.normals[n] = i
+	return i
+}
+
+func (b *meshBuilder) texcoord(t Tex) int {
+	if i, ok := b.texcoords[t]; ok {
+		return i
+	}
+	i := len(b.mesh.texcoords)
+	b.mesh.texcoords = append(b.mesh.texcoords, t)
+	b.texcoords[t] = i
+	return i
+}
+
+// bounds returns the bounding box of the vertices. It is empty at the origin
+// for a mesh without vertices.
+func (m *Mesh) bounds() AABB {
+	if len(m.vertices) == 0 {
+		return AABB{}
+	}
+	b := AABB{m.vertices[0], m.vertices[0]}
+	for _, v := range m.vertices[1:] {
+		b.min = Vec3{math.Min(v.x, b.min.x), math.Min(v.y, b.min.y), math.Min(v.z, b.min.z)}
+		b.max = Vec3{math.Max(v.x, b.max.x), math.Max(v.y, b.max.y), math.Max(v.z, b.max.z)}
+	}
+	return b
+}
+
+// boundingSphere returns a sphere around the vertices. It is centered on the
+// bounding box, so it isn't the smallest sphere but it is close for maps.
+func (m *Mesh) boundingSphere() Sphere {
+	center := m.bounds().Center()
+	var radius float64
+	for _, v := range m.vertices {
+		radius = math.Max(radius, v.Sub(center).Length())
+	}
+	return Sphere{center, radius}
+}
+
+// coordCenter returns the center of the bounding box.
+func (m *Mesh) coordCenter() Vec3 {
+	return m.bounds().Center()
+}
 
-	return Vec3{x, y, z}
+// depthAt returns the depthbuffer value at the barycentric weights.
+func (t Triangle) depthAt(w0, w1, w2 float64) float64 {
+	return t.depths[0]*w0 + t.depths[1]*w1 + t.depths[2]*w2
 }
@@ -4,16 +4,24 @@ type Model struct {
 	mesh              Mesh
 	trianglesToRender []Triangle
 	worldMatrix       Matrix
+
+	// Cached transform results. See transform.go.
+	transformKey transformKey
+	vertices     []transformedVertex
+	normals      []Vec3
+	chunks       [][]Triangle
 }
 
-func NewModel(mesh Mesh) Model {
+func NewModel(mesh Mesh) *Model {
 	m := Model{mesh: mesh}
-	m.UpdateMatrix()
-	return m
+	m.UpdateMatrix(MatrixIdentity())
+	return &m
 }
 
-func (m *Model) UpdateMatrix() {
-	m.worldMatrix = MatrixWorld(m.mesh.scale, m.mesh.rotation, m.mesh.translation)
+// UpdateMatrix sets the world matrix from the mesh transform and the world
+// matrix of the scene node the model belongs to.
+func (m *Model) UpdateMatrix(parent Matrix) {
+	m.worldMatrix = parent.Mul(MatrixWorld(m.mesh.scale, m.mesh.rotation, m.mesh.translation))
 }
 
 func (m *Model) Matrix() Matrix {
@@ -0,0 +1,148 @@
+// This file contains the map picker.
+//
+// The picker is an overlay for searching the map catalog while viewing maps.
+// Typing updates the search, the arrow keys select a map and Enter loads it.
+// The catalog is read from the disc in the background the first time the
+// picker is opened, because it reads every map. The picker can be typed in
+// while it loads.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const pickerRows = 15
+
+type catalogResult struct {
+	catalog []MapInfo
+	err     error
+}
+
+func (e *Engine) openPicker() {
+	if e.catalog == nil && e.catalogResult == nil {
+		result := make(chan catalogResult, 1)
+		e.catalogResult = result
+		go func() {
+			catalog, err := e.reader.ReadCatalog()
+			result <- catalogResult{catalog, err}
+		}()
+	}
+	e.pickerOpen = true
+	e.searchPicker()
+}
+
+// receiveCatalog sets the catalog once it is read.
+func (e *Engine) receiveCatalog() {
+	select {
+	case result := <-e.catalogResult:
+		e.catalogResult = nil
+		if result.err != nil {
+			// Try again the next time the picker is opened.
+			fmt.Fprintln(os.Stderr, "read catalog:", result.err)
+			e.pickerOpen = false
+			return
+		}
+		e.catalog = result.catalog
+		e.searchPicker()
+	default:
+	}
+}
+
+// searchPicker updates the results for the current query and selects the
+// current map if it is one of them.
+func (e *Engine) searchPicker() {
+	e.pickerResults = SearchMaps(e.catalog, e.pickerQuery)
+	e.pickerIndex = 0
+	for i, m := range e.pickerResults {
+		if m.number == e.currentMap {
+			e.pickerIndex = i
+		}
+	}
+}
+
+func (e *Engine) movePicker(step int) {
+	e.pickerIndex += step
+	if e.pickerIndex >= len(e.pickerResults) {
+		e.pickerIndex = len(e.pickerResults) - 1
+	}
+	if e.pickerIndex < 0 {
+		e.pickerIndex = 0
+	}
+}
+
+// handlePickerKey handles keys while the picker is open. All keys are used so
+// typing a search doesn't trigger other actions.
+func (e *Engine) handlePickerKey(key sdl.Keycode) {
+	switch {
+	case key == sdl.K_ESCAPE:
+		e.pickerOpen = false
+	case key == sdl.K_RETURN:
+		if len(e.pickerResults) > 0 {
+			e.pickerOpen = false
+			e.setMap(e.pickerResults[e.pickerIndex].number, 0)
+		}
+	case key == sdl.K_UP:
+		e.movePicker(-1)
+	case key == sdl.K_DOWN:
+		e.movePicker(1)
+	case key == sdl.K_PAGEUP:
+		e.movePicker(-pickerRows)
+	case key == sdl.K_PAGEDOWN:
+		e.movePicker(pickerRows)
+	case key == sdl.K_BACKSPACE:
+		if len(e.pickerQuery) > 0 {
+			e.pickerQuery = e.pickerQuery[:len(e.pickerQuery)-1]
+			e.searchPicker()
+		}
+	case key == sdl.K_SPACE:
+		e.pickerQuery += " "
+		e.searchPicker()
+	case key >= sdl.K_a && key <= sdl.K_z:
+		e.pickerQuery += string(rune('a' + key - sdl.K_a))
+		e.searchPicker()
+	case key >= sdl.K_0 && key <= sdl.K_9:
+		e.pickerQuery += string(rune('0' + key - sdl.K_0))
+		e.searchPicker()
+	}
+}
+
+// renderPicker draws the search and a page of results around the selection.
+func (e *Engine) renderPicker() {
+	e.window.TextBackground(640, 70+pickerRows*25, Color{0, 0, 0, 200})
+	e.window.SetText(10, 10, "Search: "+e.pickerQuery+"_", White)
+	if e.catalog == nil {
+		e.window.SetText(10, 35, "Loading maps...  [Esc] Close", Yellow)
+		return
+	}
+	e.window.SetText(10, 35, fmt.Sprintf("%d maps  [Up/Down] Select [Enter] Load [Esc] Close", len(e.pickerResults)), White)
+
+	start := e.pickerIndex - pickerRows/2
+	if start > len(e.pickerResults)-pickerRows {
+		start = len(e.pickerResults) - pickerRows
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	for row := 0; row < pickerRows && start+row < len(e.pickerResults); row++ {
+		i := start + row
+		m := e.pickerResults[i]
+		details := "event"
+		if m.battle {
+			details = "battle"
+		}
+		if m.area != "" {
+			details = m.area + ", " + details
+		}
+		text := fmt.Sprintf("%3d  %s  (%s, %d scenarios)", m.number, m.name, details, len(m.scenarios))
+		color := White
+		if i == e.pickerIndex {
+			color = Yellow
+			text = "> " + text
+		}
+		e.window.SetText(10, 65+row*25, text, color)
+	}
+}
@@ -0,0 +1,333 @@
+// This file contains the placement of wavefront obj props on a map.
+//
+// Props are stored per map in props/mapNNN.json. Each placement references an
+// obj file, an optional png texture and either a tile or a position in raw map
+// units. The placements are loaded when the map is loaded and saved whenever
+// they are changed in the viewer.
+//
+//	{
+//	  "props": [
+//	    {"obj": "assets/cube.obj", "tile": {"x": 3, "z": 5}, "scale": 0.25, "rotation": 90},
+//	    {"obj": "assets/teapot.obj", "texture": "teapot.png", "position": {"x": 100, "y": 24, "z": 60}}
+//	  ]
+//	}
+//
+// The origin of the obj is placed on the center of the tile or at the position.
+// The scale is in tiles per obj unit, so a cube from -1 to 1 with a scale of 0.5
+// is exactly one tile wide. The rotation is in degrees around the y axis.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	propsDir        = "props"
+	defaultPropObj  = "assets/cube.obj"
+	propRotateStep  = 45.0
+	defaultPropSize = 0.5
+)
+
+type PropFile struct {
+	Props []PropPlacement `json:"props"`
+}
+
+type PropPlacement struct {
+	Obj      string        `json:"obj"`
+	Texture  string        `json:"texture,omitempty"`
+	Tile     *TilePosition `json:"tile,omitempty"`
+	Position *MapPosition  `json:"position,omitempty"`
+	Scale    float64       `json:"scale,omitempty"`
+	Rotation float64       `json:"rotation,omitempty"`
+}
+
+type TilePosition struct {
+	X     int `json:"x"`
+	Z     int `json:"z"`
+	Level int `json:"level,omitempty"`
+}
+
+// MapPosition is a position in raw map units. A tile is 28 units wide and each
+// step of height is 12 units.
+type MapPosition struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+func propsPath(mapNum int) string {
+	return filepath.Join(propsDir, fmt.Sprintf("map%03d.json", mapNum))
+}
+
+// LoadProps reads the prop placements of a map. A map without a props file has
+// no placements.
+func LoadProps(mapNum int) ([]PropPlacement, error) {
+	data, err := os.ReadFile(propsPath(mapNum))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file PropFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", propsPath(mapNum), err)
+	}
+	return file.Props, nil
+}
+
+// SaveProps writes the prop placements of a map.
+func SaveProps(mapNum int, props []PropPlacement) error {
+	data, err := json.MarshalIndent(PropFile{Props: props}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(propsDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(propsPath(mapNum), data, 0o644)
+}
+
+// loadPropMesh loads an obj and its optional png texture.
+func loadPropMesh(obj, texture string) (Mesh, error) {
+	mesh, err := NewMeshFromObj(obj)
+	if err != nil {
+		return Mesh{}, err
+	}
+
+	if texture != "" {
+		f, err := os.Open(texture)
+		if err != nil {
+			return Mesh{}, err
+		}
+		defer f.Close()
+
+		img, err := png.Decode(f)
+		if err != nil {
+			return Mesh{}, fmt.Errorf("%s: %w", texture, err)
+		}
+		mesh.texture = NewTextureFromImage(img)
+	}
+	return mesh, nil
+}
+
+// propMesh returns the mesh for a placement. Meshes are cached so every copy of
+// a prop shares the same vertex data.
+func (e *Engine) propMesh(p PropPlacement) (Mesh, error) {
+	key := p.Obj + "|" + p.Texture
+	if mesh, ok := e.propMeshes[key]; ok {
+		return mesh, nil
+	}
+	mesh, err := loadPropMesh(p.Obj, p.Texture)
+	if err != nil {
+		return Mesh{}, err
+	}
+	e.propMeshes[key] = mesh
+	return mesh, nil
+}
+
+// propNode creates the scene node for a placement. The node is a child of the
+// map node, so its translation is in map world units.
+func (e *Engine) propNode(p PropPlacement) (*Node, error) {
+	mesh, err := e.propMesh(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var position Vec3
+	switch {
+	case p.Tile != nil:
+		center, ok := e.mapModel().mesh.terrain.TileCenter(p.Tile.X, p.Tile.Z, p.Tile.Level)
+		if !ok {
+			return nil, fmt.Errorf("tile %d,%d is outside of the map", p.Tile.X, p.Tile.Z)
+		}
+		position = center
+	case p.Position != nil:
+		position = Vec3{p.Position.X, p.Position.Y, p.Position.Z}.Div(4096.0)
+	default:
+		return nil, fmt.Errorf("%s: placement needs a tile or position", p.Obj)
+	}
+
+	scale := p.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	scale *= tileSize3D / 4096.0 * modelScale
+
+	// Props are lit like the map they are placed in. The light positions are
+	// scaled by the map scale because the transform only scales them by the
+	// prop mesh scale, which is 1.
+	mapMesh := e.mapModel().mesh
+	mesh.ambientLight = mapMesh.ambientLight
+	mesh.directionalLights = make([]DirectionalLight, len(mapMesh.directionalLights))
+	for i, light := range mapMesh.directionalLights {
+		light.position = MatrixScale(mapMesh.scale).MulVec3(light.position)
+		mesh.directionalLights[i] = light
+	}
+
+	node := NewNode(p.Obj, NewModel(mesh))
+	node.translation = position.Mul(modelScale)
+	node.scale = Vec3{scale, scale, scale}
+	node.rotation.y = p.Rotation * math.Pi / 180.0
+	return node, nil
+}
+
+// loadProps replaces the prop nodes with the placements of the current map.
+func (e *Engine) loadProps() {
+	for _, node := range e.propNodes {
+		e.mapNode.RemoveChild(node)
+	}
+	e.propNodes = e.propNodes[:0]
+
+	props, err := LoadProps(e.currentMap)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load props:", err)
+	}
+	e.props = props
+
+	for _, p := range e.props {
+		node, err := e.propNode(p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "load props:", err)
+		}
+		// Keep the nodes aligned with the placements so they can be
+		// removed by index.
+		if node == nil {
+			node = NewNode(p.Obj, nil)
+		}
+		e.propNodes = append(e.propNodes, e.mapNode.AddChild(node))
+	}
+}
+
+func (e *Engine) saveProps() {
+	if err := SaveProps(e.currentMap, e.props); err != nil {
+		fmt.Fprintln(os.Stderr, "save props:", err)
+	}
+}
+
+// placeProp places the current prop on the tile under the cursor.
+func (e *Engine) placeProp() {
+	tile := e.tileCursor
+	p := PropPlacement{Obj: e.propObj, Tile: &tile, Scale: defaultPropSize}
+	node, err := e.propNode(p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "place prop:", err)
+		return
+	}
+	e.props = append(e.props, p)
+	e.propNodes = append(e.propNodes, e.mapNode.AddChild(node))
+	e.saveProps()
+}
+
+// propsAtCursor returns the indices of the props on the tile under the cursor.
+func (e *Engine) propsAtCursor() []int {
+	var indices []int
+	for i, p := range e.props {
+		if p.Tile != nil && *p.Tile == e.tileCursor {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// removeProps removes all props on the tile under the cursor.
+func (e *Engine) removeProps() {
+	indices := e.propsAtCursor()
+	for i := len(indices) - 1; i >= 0; i-- {
+		index := indices[i]
+		e.mapNode.RemoveChild(e.propNodes[index])
+		e.props = append(e.props[:index], e.props[index+1:]...)
+		e.propNodes = append(e.propNodes[:index], e.propNodes[index+1:]...)
+	}
+	if len(indices) > 0 {
+		e.saveProps()
+	}
+}
+
+// rotateProps rotates all props on the tile under the cursor by degrees.
+func (e *Engine) rotateProps(degrees float64) {
+	indices := e.propsAtCursor()
+	for _, index := range indices {
+		e.props[index].Rotation = math.Mod(e.props[index].Rotation+degrees+360, 360)
+		e.propNodes[index].rotation.y = e.props[index].Rotation * math.Pi / 180.0
+	}
+	if len(indices) > 0 {
+		e.saveProps()
+	}
+}
+
+// moveTileCursor moves the cursor, keeping it on the map.
+func (e *Engine) moveTileCursor(dx, dz int) {
+	terrain := e.mapModel().mesh.terrain
+	x := e.tileCursor.X + dx
+	z := e.tileCursor.Z + dz
+	if _, ok := terrain.Tile(x, z, e.tileCursor.Level); ok {
+		e.tileCursor.X = x
+		e.tileCursor.Z = z
+	}
+}
+
+// handlePropKey handles keys while placing props. It returns true if the key
+// was used.
+func (e *Engine) handlePropKey(key sdl.Keycode) bool {
+	switch key {
+	case sdl.K_LEFT:
+		e.moveTileCursor(-1, 0)
+	case sdl.K_RIGHT:
+		e.moveTileCursor(1, 0)
+	case sdl.K_UP:
+		e.moveTileCursor(0, 1)
+	case sdl.K_DOWN:
+		e.moveTileCursor(0, -1)
+	case sdl.K_TAB:
+		e.tileCursor.Level = (e.tileCursor.Level + 1) % terrainLevels
+	case sdl.K_RETURN:
+		e.placeProp()
+	case sdl.K_BACKSPACE, sdl.K_DELETE:
+		e.removeProps()
+	case sdl.K_COMMA:
+		e.rotateProps(-propRotateStep)
+	case sdl.K_PERIOD:
+		e.rotateProps(propRotateStep)
+	default:
+		return false
+	}
+	return true
+}
+
+// drawTileCursor outlines the tile under the cursor.
+func (e *Engine) drawTileCursor() {
+	terrain := e.mapModel().mesh.terrain
+	center, ok := terrain.TileCenter(e.tileCursor.X, e.tileCursor.Z, e.tileCursor.Level)
+	if !ok {
+		return
+	}
+
+	half := tileSize3D / 4096.0 / 2.0
+	corners := [4]Vec3{
+		center.Add(Vec3{-half, 0, -half}),
+		center.Add(Vec3{half, 0, -half}),
+		center.Add(Vec3{half, 0, half}),
+		center.Add(Vec3{-half, 0, half}),
+	}
+
+	var points [4]Vec2
+	key := e.transformKey(e.mapModel().Matrix())
+	for i, corner := range corners {
+		points[i] = transformVertex(corner, key).screen
+	}
+	for i := range points {
+		a, b := points[i], points[(i+1)%len(points)]
+		e.renderer.DrawLine(int(a.x), int(a.y), int(b.x), int(b.y), Yellow)
+	}
+}
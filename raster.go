@@ -0,0 +1,153 @@
+// This file contains the triangle rasterizer shared by the filled, textured and
+// gouraud drawing functions.
+//
+// It is a bounding box rasterizer using edge functions. For every pixel in the
+// triangle's bounding box we evaluate the three edge functions at the pixel
+// center. If all three are positive the pixel is inside the triangle and the
+// values, divided by the triangle area, are the barycentric weights.
+//
+// Vertices are snapped to a fixed-point sub-pixel grid so the edge functions are
+// evaluated exactly with integers. Two triangles sharing an edge compute the same
+// values for that edge, and the top-left fill rule decides which of the two owns
+// pixels that land exactly on it. This means there are no gaps or overlaps
+// between adjacent triangles.
+package main
+
+import "math"
+
+const (
+	// Number of sub-pixel bits for vertex positions (1/16th of a pixel).
+	subPixelBits = 4
+	subPixelStep = 1 << subPixelBits
+	subPixelHalf = subPixelStep / 2
+)
+
+// rect is an inclusive pixel rectangle used to clip rasterization.
+type rect struct {
+	minX, minY, maxX, maxY int
+}
+
+// fragmentFunc is called for every pixel covered by a triangle. The weights
+// are the barycentric weights of the pixel center for vertex a, b and c.
+type fragmentFunc func(x, y int, w0, w1, w2 float64)
+
+// toFixed converts a screen coordinate to the fixed-point sub-pixel grid.
+func toFixed(f float64) int64 {
+	return int64(math.Round(f * subPixelStep))
+}
+
+// edgeFunction returns twice the signed area of the triangle a, b, p. It is
+// positive when p is on the inside of the edge a->b for a triangle with
+// clockwise screen winding (y-axis down).
+func edgeFunction(ax, ay, bx, by, px, py int64) int64 {
+	return (bx-ax)*(py-ay) - (by-ay)*(px-ax)
+}
+
+// isTopLeft returns true if the edge a->b is a top or left edge of a triangle
+// with clockwise screen winding. A top edge is horizontal with the rest of the
+// triangle below it. A left edge goes up the screen.
+func isTopLeft(ax, ay, bx, by int64) bool {
+	dx, dy := bx-ax, by-ay
+	return (dy == 0 && dx > 0) || dy < 0
+}
+
+// rasterize calls fn for every pixel center inside the triangle and the clip
+// rectangle. Pixels that lie exactly on an edge are only drawn for top and left
+// edges.
+func (r *Renderer) rasterize(points [3]Vec2, clip rect, fn fragmentFunc) {
+	ax, ay := toFixed(points[0].x), toFixed(points[0].y)
+	bx, by := toFixed(points[1].x), toFixed(points[1].y)
+	cx, cy := toFixed(points[2].x), toFixed(points[2].y)
+
+	area := edgeFunction(ax, ay, bx, by, cx, cy)
+	if area == 0 {
+		return
+	}
+
+	// The edge functions assume clockwise winding. Culled triangles are never
+	// drawn, but wireframe and debug drawing might pass either winding so we
+	// swap b and c instead of skipping the triangle. swapped tracks this so the
+	// weights are still reported in the original vertex order.
+	swapped := area < 0
+	if swapped {
+		bx, by, cx, cy = cx, cy, bx, by
+		area = -area
+	}
+
+	// Bounding box in whole pixels, clipped to the clip rectangle.
+	minX := int(math.Max(float64(clip.minX), math.Floor(float64(min3(ax, bx, cx))/subPixelStep)))
+	minY := int(math.Max(float64(clip.minY), math.Floor(float64(min3(ay, by, cy))/subPixelStep)))
+	maxX := int(math.Min(float64(clip.maxX), math.Ceil(float64(max3(ax, bx, cx))/subPixelStep)))
+	maxY := int(math.Min(float64(clip.maxY), math.Ceil(float64(max3(ay, by, cy))/subPixelStep)))
+	if minX > maxX || minY > maxY {
+		return
+	}
+
+	// Pixels exactly on an edge are only inside if the edge is a top or left
+	// edge. Biasing the other edges by one makes the test a simple >= 0.
+	var bias0, bias1, bias2 int64
+	if !isTopLeft(bx, by, cx, cy) {
+		bias0 = -1
+	}
+	if !isTopLeft(cx, cy, ax, ay) {
+		bias1 = -1
+	}
+	if !isTopLeft(ax, ay, bx, by) {
+		bias2 = -1
+	}
+
+	// Edge function values at the center of the first pixel and their change
+	// when stepping one pixel in x or y.
+	px := int64(minX)*subPixelStep + subPixelHalf
+	py := int64(minY)*subPixelStep + subPixelHalf
+	row0 := edgeFunction(bx, by, cx, cy, px, py) + bias0
+	row1 := edgeFunction(cx, cy, ax, ay, px, py) + bias1
+	row2 := edgeFunction(ax, ay, bx, by, px, py) + bias2
+
+	stepX0, stepY0 := (by-cy)*subPixelStep, (cx-bx)*subPixelStep
+	stepX1, stepY1 := (cy-ay)*subPixelStep, (ax-cx)*subPixelStep
+	stepX2, stepY2 := (ay-by)*subPixelStep, (bx-ax)*subPixelStep
+
+	invArea := 1.0 / float64(area)
+	for y := minY; y <= maxY; y++ {
+		e0, e1, e2 := row0, row1, row2
+		for x := minX; x <= maxX; x++ {
+			if e0|e1|e2 >= 0 {
+				// Remove the bias so the weights are exact.
+				w0 := float64(e0-bias0) * invArea
+				w1 := float64(e1-bias1) * invArea
+				w2 := float64(e2-bias2) * invArea
+				if swapped {
+					w1, w2 = w2, w1
+				}
+				fn(x, y, w0, w1, w2)
+			}
+			e0 += stepX0
+			e1 += stepX1
+			e2 += stepX2
+		}
+		row0 += stepY0
+		row1 += stepY1
+		row2 += stepY2
+	}
+}
+
+func min3(a, b, c int64) int64 {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func max3(a, b, c int64) int64 {
+	if b > a {
+		a = b
+	}
+	if c > a {
+		a = c
+	}
+	return a
+}
@@ -4,12 +4,33 @@ import (
 	"math"
 )
 
+// RenderOptions controls how triangles are drawn.
+type RenderOptions struct {
+	showTexture    bool
+	showWireframe  bool
+	showLighting   bool
+	bilinearFilter bool
+	smoothShading  bool
+}
+
+func DefaultRenderOptions() RenderOptions {
+	return RenderOptions{
+		showTexture:  true,
+		showLighting: true,
+	}
+}
+
 type Renderer struct {
 	window *Window
+
+	// Tiles for concurrent rasterization. See tiles.go.
+	tiles                   []tile
+	tilesX                  int
+	tiledWidth, tiledHeight int
 }
 
 func NewRenderer(window *Window) *Renderer {
-	return &Renderer{window}
+	return &Renderer{window: window}
 }
 
 func (r *Renderer) DrawRect(x, y, w, h int, color Color) {
@@ -20,6 +41,31 @@ func (r *Renderer) DrawRect(x, y, w, h int, color Color) {
 	}
 }
 
+// DrawImage draws a texture centered in the colorbuffer, scaled to fit with
+// nearest neighbor sampling. Transparent texels are skipped.
+func (r *Renderer) DrawImage(t Texture) {
+	r.DrawImageRect(t, 0, 0, r.window.bufferWidth, r.window.bufferHeight)
+}
+
+// DrawImageRect draws a texture centered in a rectangle of the colorbuffer,
+// scaled to fit with nearest neighbor sampling. Transparent texels are
+// skipped.
+func (r *Renderer) DrawImageRect(t Texture, x, y, width, height int) {
+	scale := math.Min(float64(width)/float64(t.width), float64(height)/float64(t.height))
+	w, h := int(float64(t.width)*scale), int(float64(t.height)*scale)
+	x0, y0 := x+(width-w)/2, y+(height-h)/2
+
+	for ty := 0; ty < h; ty++ {
+		for tx := 0; tx < w; tx++ {
+			color := t.texel(int(float64(tx)/scale), int(float64(ty)/scale))
+			if color.A == 0 {
+				continue
+			}
+			r.window.SetPixel(x0+tx, y0+ty, color)
+		}
+	}
+}
+
 // DrawLine draws a solid line using the DDA algorithm.
 func (r *Renderer) DrawLine(x0, y0, x1, y1 int, color Color) {
 	deltaX := x1 - x0
@@ -55,212 +101,153 @@ func (r *Renderer) DrawTriangle(t Triangle) {
 
 }
 
-func (r *Renderer) DrawFilledTriangle(t Triangle) {
-	ax, ay := int(t.points[0].x), int(t.points[0].y)
-	bx, by := int(t.points[1].x), int(t.points[1].y)
-	cx, cy := int(t.points[2].x), int(t.points[2].y)
-
-	// We need to sort the vertices by y-coordinate ascending (y0 < y1 < y2)
-	if ay > by {
-		ay, by = by, ay
-		ax, bx = bx, ax
-	}
-	if by > cy {
-		by, cy = cy, by
-		bx, cx = cx, bx
-	}
-	if ay > by {
-		ay, by = by, ay
-		ax, bx = bx, ax
-	}
-
-	if by == cy {
-		// Draw flat-bottom triangle
-		r.fillFlatBottomTriangle(ax, ay, bx, by, cx, cy, t)
-	} else if ay == by {
-		// Draw flat-top triangle
-		r.fillFlatTopTriangle(ax, ay, bx, by, cx, cy, t)
+// drawTriangle draws a triangle, clipped to clip, using the texture and shading
+// options.
+func (r *Renderer) drawTriangle(t Triangle, clip rect, options RenderOptions) {
+	if options.showTexture && t.texture != nil {
+		r.drawTexturedTriangle(t, clip, options)
+	} else if options.smoothShading {
+		r.drawGouraudTriangle(t, clip)
 	} else {
-		// Calculate the new vertex (Mx,My) using triangle similarity
-		My := by
-		Mx := (((cx - ax) * (by - ay)) / (cy - ay)) + ax
-
-		// Draw flat-bottom triangle
-		r.fillFlatBottomTriangle(ax, ay, bx, by, Mx, My, t)
-
-		// Draw flat-top triangle
-		r.fillFlatTopTriangle(bx, by, Mx, My, cx, cy, t)
+		r.drawFilledTriangle(t, clip)
 	}
 }
 
-func (r *Renderer) DrawTexturedTriangle(t Triangle, texture Texture) {
-	ax, ay := int(t.points[0].x), int(t.points[0].y)
-	bx, by := int(t.points[1].x), int(t.points[1].y)
-	cx, cy := int(t.points[2].x), int(t.points[2].y)
-	at, bt, ct := t.texcoords[0], t.texcoords[1], t.texcoords[2]
-
-	// We need to sort the vertices by y-coordinate ascending (y0 < y1 < y2)
-	if ay > by {
-		ay, by = by, ay
-		ax, bx = bx, ax
-		at, bt = bt, at
-	}
-	if by > cy {
-		by, cy = cy, by
-		bx, cx = cx, bx
-		bt, ct = ct, bt
-	}
-	if ay > by {
-		ay, by = by, ay
-		ax, bx = bx, ax
-		at, bt = bt, at
-	}
-
-	// Create vector points and texture coords after we sort the vertices
-	a := Vec2{float64(ax), float64(ay)}
-	b := Vec2{float64(bx), float64(by)}
-	c := Vec2{float64(cx), float64(cy)}
+// drawFilledTriangle draws a triangle with a single lit color.
+func (r *Renderer) drawFilledTriangle(t Triangle, clip rect) {
+	color := t.color.Mul(t.lightColor)
+	r.rasterize(t.points, clip, func(x, y int, w0, w1, w2 float64) {
+		depth := t.depthAt(w0, w1, w2)
+		if r.window.DepthTest(x, y, depth) {
+			r.window.SetPixelDepth(x, y, depth, color)
+		}
+	})
+}
 
-	//
-	// Render the upper part of the triangle (flat-bottom)
-	//
-	invSlope1 := 0.0
-	invSlope2 := 0.0
+// drawGouraudTriangle draws a triangle with the vertex light colors
+// interpolated across the face.
+func (r *Renderer) drawGouraudTriangle(t Triangle, clip rect) {
+	a, b, c := t.lightColors[0], t.lightColors[1], t.lightColors[2]
+	r.rasterize(t.points, clip, func(x, y int, w0, w1, w2 float64) {
+		depth := t.depthAt(w0, w1, w2)
+		if r.window.DepthTest(x, y, depth) {
+			r.window.SetPixelDepth(x, y, depth, t.color.Mul(interpolateColor(a, b, c, w0, w1, w2)))
+		}
+	})
+}
 
-	if by-ay != 0 {
-		invSlope1 = float64(bx-ax) / float64(abs(by-ay))
-	}
-	if cy-ay != 0 {
-		invSlope2 = float64(cx-ax) / float64(abs(cy-ay))
-	}
+func (r *Renderer) drawTexturedTriangle(t Triangle, clip rect, options RenderOptions) {
+	r.rasterize(t.points, clip, func(x, y int, w0, w1, w2 float64) {
+		depth := t.depthAt(w0, w1, w2)
+		if r.window.DepthTest(x, y, depth) {
+			r.drawTexel(x, y, depth, t, w0, w1, w2, options)
+		}
+	})
+}
 
-	if by-ay != 0 {
-		for y := ay; y <= by; y++ {
-			var xStart int = int(float64(bx) + (float64(y-by) * invSlope1))
-			var xEnd int = int(float64(ax) + (float64(y-ay) * invSlope2))
+func (r *Renderer) drawTexel(x, y int, depth float64, t Triangle, alpha, beta, gamma float64, options RenderOptions) {
+	texture := t.texture
+	at, bt, ct := t.texcoords[0], t.texcoords[1], t.texcoords[2]
 
-			if xEnd < xStart {
-				xStart, xEnd = xEnd, xStart // swap if xStart is to the right of xEnd
-			}
+	// Perform the interpolation of all U and V values using barycentric weights
+	interpolatedU := at.u*alpha + bt.u*beta + ct.u*gamma
+	interpolatedV := at.v*alpha + bt.v*beta + ct.v*gamma
 
-			for x := xStart; x < xEnd; x++ {
-				// Draw our pixel with the color that comes from the texture
-				r.drawTexel(x, y, texture, t, a, b, c, at, bt, ct)
-			}
+	var textureColor Color
+	if options.bilinearFilter {
+		color, ok := sampleBilinear(*texture, t.palette, interpolatedU, interpolatedV)
+		if !ok {
+			return
 		}
-	}
-
-	//
-	// Render the bottom part of the triangle (flat-top)
-	//
-	invSlope1 = 0.0
-	invSlope2 = 0.0
+		textureColor = color
+	} else {
+		// Map the UV coordinate to the full texture width and height
+		texX := int(interpolatedU * float64(texture.width))
+		texY := int(interpolatedV * float64(texture.height))
 
-	if cy-by != 0 {
-		invSlope1 = float64(cx-bx) / float64(abs(cy-by))
-	}
-	if cy-ay != 0 {
-		invSlope2 = float64(cx-ax) / float64(abs(cy-ay))
-	}
+		// Validate the index is inside the texture.
+		index := (texY * texture.width) + texX
+		if index < 0 || index >= texture.width*texture.height {
+			return
+		}
 
-	if cy-by != 0 {
-		for y := by; y <= cy; y++ {
-			var xStart int = int(float64(bx) + (float64(y-by) * invSlope1))
-			var xEnd int = int(float64(ax) + (float64(y-ay) * invSlope2))
+		textureColor = texture.data[index]
 
-			if xEnd < xStart {
-				xStart, xEnd = xEnd, xStart // swap if xStart is to the right of xEnd
-			}
+		// If there is a palette, the current color components will
+		// represent the index into the palette.
+		if t.palette != nil {
+			textureColor = t.palette[textureColor.R]
 
-			for x := xStart; x < xEnd; x++ {
-				// Draw our pixel with the color that comes from the texture
-				r.drawTexel(x, y, texture, t, a, b, c, at, bt, ct)
+			// Transparent texture
+			if textureColor.isTrans() {
+				return
 			}
 		}
 	}
-}
-
-func (r *Renderer) fillFlatBottomTriangle(ax, ay, bx, by, cx, cy int, t Triangle) {
-	// Find the two slopes (two triangle legs)
-	invSlope1 := float64(bx-ax) / float64(by-ay)
-	invSlope2 := float64(cx-ax) / float64(cy-ay)
 
-	// Start xStart and xEnd from the top vertex (x0,y0)
-	xStart := float64(ax)
-	xEnd := float64(ax)
-
-	// Loop all the scanlines from top to bottom
-	color := t.color.Mul(t.lightColor)
-	for y := ay; y <= cy; y++ {
-		r.DrawLine(int(xStart), y, int(xEnd), y, color)
-		xStart += invSlope1
-		xEnd += invSlope2
+	if t.palette != nil && options.showLighting {
+		if options.smoothShading {
+			light := interpolateColor(t.lightColors[0], t.lightColors[1], t.lightColors[2], alpha, beta, gamma)
+			textureColor = textureColor.Mul(light)
+		} else {
+			textureColor = textureColor.Mul(t.lightColor)
+		}
 	}
-}
-
-func (r *Renderer) fillFlatTopTriangle(ax, ay, bx, by, cx, cy int, t Triangle) {
-	// Find the two slopes (two triangle legs)
-	invSlope1 := float64(cx-ax) / float64(cy-ay)
-	invSlope2 := float64(cx-bx) / float64(cy-by)
-
-	// Start xStart and xEnd from the bottom vertex (x2,y2)
-	xStart := float64(cx)
-	xEnd := float64(cx)
 
-	// Loop all the scanlines from bottom to top
-	color := t.color.Mul(t.lightColor)
-	for y := cy; y >= ay; y-- {
-		r.DrawLine(int(xStart), y, int(xEnd), y, color)
-		xStart -= invSlope1
-		xEnd -= invSlope2
-	}
+	r.window.SetPixelDepth(x, y, depth, textureColor)
 }
 
-func (r *Renderer) drawTexel(x, y int, texture Texture, t Triangle, a, b, c Vec2, at, bt, ct Tex) {
-	p := Vec2{float64(x), float64(y)}
-	alpha, beta, gamma := barycentricWeights(a, b, c, p)
-
-	// Perform the interpolation of all U and V values using barycentric weights
-	interpolatedU := at.u*alpha + bt.u*beta + ct.u*gamma
-	interpolatedV := at.v*alpha + bt.v*beta + ct.v*gamma
-
-	// Map the UV coordinate to the full texture width and height
-	texX := int(interpolatedU * float64(texture.width))
-	texY := int(interpolatedV * float64(texture.height))
-
-	// Validate the index is inside the texture.
-	index := (texY * texture.width) + texX
-	if index < 0 || index > texture.width*texture.height {
-		return
+// sampleBilinear returns the texture color at u,v blended from the four nearest
+// texels.
+//
+// FFT texels are palette indices, so each of the four texels is resolved through
+// the palette before blending. Blending the indices themselves would pick some
+// unrelated palette entry. Transparent texels don't contribute to the blend,
+// otherwise cutout edges would fade to black. If less than half of the weight
+// is opaque the sample is treated as transparent and false is returned.
+func sampleBilinear(texture Texture, palette Palette, u, v float64) (Color, bool) {
+	// Offset by half a texel so the texel centers are at whole numbers.
+	fx := u*float64(texture.width) - 0.5
+	fy := v*float64(texture.height) - 0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+
+	samples := [4]struct {
+		x, y   int
+		weight float64
+	}{
+		{x0, y0, (1 - tx) * (1 - ty)},
+		{x0 + 1, y0, tx * (1 - ty)},
+		{x0, y0 + 1, (1 - tx) * ty},
+		{x0 + 1, y0 + 1, tx * ty},
 	}
 
-	textureColor := texture.data[index]
-
-	// If there is a palette, the current color components will
-	// represent the index into the palette.
-	if t.palette != nil {
-		textureColor = t.palette[textureColor.R]
-
-		if showLighting {
-			textureColor = textureColor.Mul(t.lightColor)
+	var r, g, b, a, total float64
+	for _, s := range samples {
+		color := texture.texel(s.x, s.y)
+		if palette != nil {
+			color = palette[color.R]
 		}
-		// Transparent texture
-		if textureColor.isTrans() {
-			return
+		if color.isTrans() {
+			continue
 		}
+		r += float64(color.R) * s.weight
+		g += float64(color.G) * s.weight
+		b += float64(color.B) * s.weight
+		a += float64(color.A) * s.weight
+		total += s.weight
 	}
 
-	r.window.SetPixel(x, y, textureColor)
-
-}
+	if total < 0.5 {
+		return Transparent, false
+	}
 
-func barycentricWeights(a, b, c, p Vec2) (float64, float64, float64) {
-	ab, ac, ap := b.Sub(a), c.Sub(a), p.Sub(a)
-	den := ab.x*ac.y - ac.x*ab.y
-	v := (ap.x*ac.y - ac.x*ap.y) / den
-	w := (ab.x*ap.y - ap.x*ab.y) / den
-	u := 1.0 - v - w
-	return u, v, w
+	return Color{
+		R: uint8(r / total),
+		G: uint8(g / total),
+		B: uint8(b / total),
+		A: uint8(a / total),
+	}, true
 }
 
 var origin = Vec3{0, 0, 0}
@@ -288,12 +275,12 @@ func (r *Renderer) DrawOriginAxis(camera *Camera) {
 		vertex.y *= -1
 
 		// Scale to the viewport
-		vertex.x *= float64(r.window.width / 2)
-		vertex.y *= float64(r.window.height / 2)
+		vertex.x *= float64(r.window.bufferWidth / 2)
+		vertex.y *= float64(r.window.bufferHeight / 2)
 
 		// Translate to center of screen
-		vertex.x += float64(r.window.width / 2)
-		vertex.y += float64(r.window.height / 2)
+		vertex.x += float64(r.window.bufferWidth / 2)
+		vertex.y += float64(r.window.bufferHeight / 2)
 
 		vertices[i] = vertex
 	}
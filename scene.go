@@ -0,0 +1,71 @@
+// This file contains the scene graph.
+//
+// A Scene is a tree of Nodes. Each node has a transform relative to its parent,
+// an optional Model and any number of children. The world matrix of a node is
+// the world matrix of its parent multiplied by its own local matrix, so moving
+// a node moves everything below it.
+//
+// The current map is a node directly below the root. Props, markers and
+// animated sub-meshes can be children of the map so they follow it when it is
+// rotated or moved.
+package main
+
+type Node struct {
+	name        string
+	scale       Vec3
+	rotation    Vec3
+	translation Vec3
+
+	// Optional. Nodes without a model are only used to group and transform
+	// their children.
+	model *Model
+
+	children []*Node
+}
+
+func NewNode(name string, model *Model) *Node {
+	return &Node{name: name, scale: Vec3{1, 1, 1}, model: model}
+}
+
+// AddChild adds a child node and returns it.
+func (n *Node) AddChild(child *Node) *Node {
+	n.children = append(n.children, child)
+	return child
+}
+
+// RemoveChild removes a direct child of the node.
+func (n *Node) RemoveChild(child *Node) {
+	for i, c := range n.children {
+		if c == child {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// LocalMatrix returns the transform of the node relative to its parent.
+func (n *Node) LocalMatrix() Matrix {
+	return MatrixWorld(n.scale, n.rotation, n.translation)
+}
+
+type Scene struct {
+	root *Node
+}
+
+func NewScene() *Scene {
+	return &Scene{root: NewNode("root", nil)}
+}
+
+// Walk calls fn for every node in the scene, parents before children, with the
+// node's world matrix.
+func (s *Scene) Walk(fn func(node *Node, world Matrix)) {
+	walkNode(s.root, MatrixIdentity(), fn)
+}
+
+func walkNode(n *Node, parent Matrix, fn func(node *Node, world Matrix)) {
+	world := parent.Mul(n.LocalMatrix())
+	fn(n, world)
+	for _, child := range n.children {
+		walkNode(child, world, fn)
+	}
+}
@@ -0,0 +1,244 @@
+// This file contains unit sprite sheets and drawing units on a map.
+//
+// Unit sprite sheets are the BATTLE/*.SPR files. Each one starts with 16
+// palettes of 16 RGB15 colors. The first 8 are the unit palettes and the last
+// 8 are for portraits. The palettes are followed by 4-bit pixels, 256 pixels
+// (128 bytes) per row. Only the first 256 rows are stored uncompressed, the
+// rest of the sheet (portraits and some extra frames) is compressed and is not
+// decoded.
+//
+// Palette index 0 is transparent, like map textures.
+//
+// The units of a map are stored in formations/mapNNN.json. Each unit references
+// a sprite sheet, a palette, a tile and the frame of the sheet to draw.
+//
+//	{
+//	  "units": [
+//	    {"sprite": "RAMUZA.SPR", "tile": {"x": 3, "z": 5}},
+//	    {"sprite": "KNIGHT_M.SPR", "palette": 2, "tile": {"x": 4, "z": 5}, "frame": {"x": 48, "y": 0, "w": 24, "h": 40}}
+//	  ]
+//	}
+//
+// Frames are rectangles in sheet pixels. The animation data in the SHP files is
+// not parsed, so the default frame is just the first standing pose of most
+// sheets.
+//
+// Units are drawn as billboards. A quad the size of the frame stands on the
+// center of the tile and is turned to face the camera every frame. The quad is
+// depth tested like the rest of the map, so units are hidden behind walls.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	formationsDir = "formations"
+
+	spritePaletteLen = 16 * 16 * 2
+	spriteWidth      = 256
+	spriteRowLen     = spriteWidth / 2
+	spriteMaxRows    = 256
+)
+
+// defaultFrame is the first standing pose on most unit sheets.
+var defaultFrame = FrameRect{X: 0, Y: 0, W: 24, H: 40}
+
+type SpriteSheet struct {
+	texture  Texture
+	palettes []Palette
+}
+
+type FormationFile struct {
+	Units []UnitPlacement `json:"units"`
+}
+
+type UnitPlacement struct {
+	Sprite  string       `json:"sprite"`
+	Palette int          `json:"palette,omitempty"`
+	Tile    TilePosition `json:"tile"`
+	Frame   *FrameRect   `json:"frame,omitempty"`
+}
+
+// FrameRect is a rectangle of a sprite sheet in pixels.
+type FrameRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// ReadSpriteSheet reads a unit sprite sheet from the BATTLE directory, like
+// "RAMUZA.SPR".
+func (r Reader) ReadSpriteSheet(name string) (SpriteSheet, error) {
+	entry, err := r.findFile("BATTLE/" + name)
+	if err != nil {
+		return SpriteSheet{}, err
+	}
+	if entry.size < spritePaletteLen+spriteRowLen {
+		return SpriteSheet{}, fmt.Errorf("%s: too small for a sprite sheet", name)
+	}
+
+	data, err := r.readFile(entry.sector, entry.size)
+	if err != nil {
+		return SpriteSheet{}, err
+	}
+	f := MeshFile{data, 0}
+
+	palettes := make([]Palette, 16)
+	for i := range palettes {
+		palette := make(Palette, 16)
+		for j := range palette {
+			palette[j] = f.readRGB15()
+		}
+		palettes[i] = palette
+	}
+
+	rows := (len(data) - spritePaletteLen) / spriteRowLen
+	if rows > spriteMaxRows {
+		rows = spriteMaxRows
+	}
+	pixels := splitPixels(data[spritePaletteLen : spritePaletteLen+rows*spriteRowLen])
+
+	return SpriteSheet{
+		texture:  NewTexture(spriteWidth, rows, pixels),
+		palettes: palettes,
+	}, nil
+}
+
+func formationPath(mapNum int) string {
+	return filepath.Join(formationsDir, fmt.Sprintf("map%03d.json", mapNum))
+}
+
+// LoadFormation reads the unit placements of a map. A map without a formation
+// file has no units.
+func LoadFormation(mapNum int) ([]UnitPlacement, error) {
+	data, err := os.ReadFile(formationPath(mapNum))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file FormationFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", formationPath(mapNum), err)
+	}
+	return file.Units, nil
+}
+
+// spriteSheet returns a sprite sheet from the disc. Sheets are cached since
+// many units share the same one.
+func (e *Engine) spriteSheet(name string) (SpriteSheet, error) {
+	if sheet, ok := e.spriteSheets[name]; ok {
+		return sheet, nil
+	}
+	sheet, err := e.reader.ReadSpriteSheet(name)
+	if err != nil {
+		return SpriteSheet{}, err
+	}
+	e.spriteSheets[name] = sheet
+	return sheet, nil
+}
+
+// billboardMesh returns a quad showing frame of the sheet. The quad is in the
+// XY plane facing -Z with the bottom center at the origin. One unit is one
+// pixel of the sheet.
+func billboardMesh(sheet SpriteSheet, palette Palette, frame FrameRect) Mesh {
+	w, h := float64(frame.W), float64(frame.H)
+	tl := Vec3{-w / 2, h, 0}
+	tr := Vec3{w / 2, h, 0}
+	br := Vec3{w / 2, 0, 0}
+	bl := Vec3{-w / 2, 0, 0}
+
+	sw, sh := float64(sheet.texture.width), float64(sheet.texture.height)
+	u0, u1 := float64(frame.X)/sw, float64(frame.X+frame.W)/sw
+	v0, v1 := float64(frame.Y)/sh, float64(frame.Y+frame.H)/sh
+	tlt, trt, brt, blt := Tex{u0, v0}, Tex{u1, v0}, Tex{u1, v1}, Tex{u0, v1}
+
+	mesh := NewMesh()
+	mesh.texture = sheet.texture
+	// Sprites are not lit. A white ambient light with no directional lights
+	// leaves the palette colors unchanged.
+	mesh.ambientLight = AmbientLight{color: White}
+
+	builder := newMeshBuilder(&mesh)
+	builder.addFace([3]Vec3{tl, tr, br}, [3]Vec3{}, [3]Tex{tlt, trt, brt}, palette, White)
+	builder.addFace([3]Vec3{tl, br, bl}, [3]Vec3{}, [3]Tex{tlt, brt, blt}, palette, White)
+	return mesh
+}
+
+// unitNode creates the scene node for a unit. The node is a child of the map
+// node, so its translation is in map world units.
+func (e *Engine) unitNode(u UnitPlacement) (*Node, error) {
+	sheet, err := e.spriteSheet(u.Sprite)
+	if err != nil {
+		return nil, err
+	}
+	if u.Palette < 0 || u.Palette >= len(sheet.palettes) {
+		return nil, fmt.Errorf("%s: invalid palette %d", u.Sprite, u.Palette)
+	}
+
+	frame := defaultFrame
+	if u.Frame != nil {
+		frame = *u.Frame
+	}
+
+	center, ok := e.mapModel().mesh.terrain.TileCenter(u.Tile.X, u.Tile.Z, u.Tile.Level)
+	if !ok {
+		return nil, fmt.Errorf("tile %d,%d is outside of the map", u.Tile.X, u.Tile.Z)
+	}
+
+	// One sprite pixel is about one raw map unit.
+	scale := modelScale / 4096.0
+
+	node := NewNode(u.Sprite, NewModel(billboardMesh(sheet, sheet.palettes[u.Palette], frame)))
+	node.translation = center.Mul(modelScale)
+	node.scale = Vec3{scale, scale, scale}
+	return node, nil
+}
+
+// loadUnits replaces the unit nodes with the formation of the current map.
+func (e *Engine) loadUnits() {
+	for _, node := range e.unitNodes {
+		e.mapNode.RemoveChild(node)
+	}
+	e.unitNodes = e.unitNodes[:0]
+
+	units, err := LoadFormation(e.currentMap)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load formation:", err)
+	}
+
+	for _, u := range units {
+		node, err := e.unitNode(u)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "load formation:", err)
+			continue
+		}
+		e.unitNodes = append(e.unitNodes, e.mapNode.AddChild(node))
+	}
+}
+
+// faceCamera turns the unit billboards toward the camera.
+//
+// The quad faces -Z, so it is rotated until its +Z axis points along the view
+// direction. The nodes are children of the map node, so the rotation of the
+// map is taken out of the yaw.
+func (e *Engine) faceCamera() {
+	forward := e.camera.front.Sub(e.camera.eye).Normalize()
+	pitch := -math.Asin(forward.y)
+	yaw := math.Atan2(forward.x, forward.z) - e.mapNode.rotation.y
+
+	for _, node := range e.unitNodes {
+		node.rotation.x = pitch
+		node.rotation.y = yaw
+	}
+}
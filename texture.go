@@ -2,6 +2,9 @@ package main
 
 import (
 	"image"
+	"image/color"
+	"image/png"
+	"os"
 )
 
 type Texture struct {
@@ -32,8 +35,62 @@ func NewTextureFromImage(image image.Image) Texture {
 	return Texture{width, height, data}
 }
 
+// texel returns the raw texel at x,y. Coordinates outside of the texture are
+// clamped to the nearest edge.
+func (t Texture) texel(x, y int) Color {
+	if x < 0 {
+		x = 0
+	} else if x >= t.width {
+		x = t.width - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= t.height {
+		y = t.height - 1
+	}
+	return t.data[(y*t.width)+x]
+}
+
 // Palette represents the 16-color palette to use during rendering a polygon.  This is due
 // to FFT texture storage. The raw texture pixel value is an index for a palettes. Each
 // map has 16 palettes of 16 colors each. Each polygon references one of the 16 palettes
 // to use.  Eventually Renderer.DrawTexel() function uses uses the pallet.
 type Palette []Color
+
+// Image returns the texture as an image. Palette textures should be resolved
+// to colors first.
+func (t Texture) Image() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, t.width, t.height))
+	for y := 0; y < t.height; y++ {
+		for x := 0; x < t.width; x++ {
+			c := t.data[(y*t.width)+x]
+			img.SetNRGBA(x, y, color.NRGBA{R: c.R, G: c.G, B: c.B, A: c.A})
+		}
+	}
+	return img
+}
+
+// resolve returns a copy of a palette texture with the palette indices
+// replaced by their colors.
+func (t Texture) resolve(palette Palette) Texture {
+	data := make([]Color, len(t.data))
+	for i, c := range t.data {
+		if int(c.R) < len(palette) {
+			data[i] = palette[c.R]
+		}
+	}
+	return NewTexture(t.width, t.height, data)
+}
+
+// writePNG writes an image to a PNG file.
+func writePNG(file string, img image.Image) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
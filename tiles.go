@@ -0,0 +1,109 @@
+// This file contains the tile based triangle drawing.
+//
+// The colorbuffer is split into square tiles. After projection each triangle is
+// binned into every tile its bounding box overlaps. The tiles are then
+// rasterized concurrently by a pool of workers, each rasterizing its tile's
+// triangles clipped to the tile.
+//
+// Every pixel belongs to exactly one tile and each tile draws its triangles in
+// the same order they were submitted, so the output is identical to drawing
+// them one after another on a single goroutine.
+package main
+
+import (
+	"math"
+	"runtime"
+	"sync"
+)
+
+const tileSize = 64
+
+type tile struct {
+	bounds    rect
+	triangles []int // Indices into the submitted triangles, in draw order.
+}
+
+// resizeTiles rebuilds the tile grid if the colorbuffer size has changed.
+func (r *Renderer) resizeTiles() {
+	w, h := r.window.bufferWidth, r.window.bufferHeight
+	if w == r.tiledWidth && h == r.tiledHeight {
+		return
+	}
+
+	tilesX := (w + tileSize - 1) / tileSize
+	tilesY := (h + tileSize - 1) / tileSize
+	r.tiledWidth, r.tiledHeight = w, h
+	r.tilesX = tilesX
+	r.tiles = make([]tile, 0, tilesX*tilesY)
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			r.tiles = append(r.tiles, tile{bounds: rect{
+				minX: tx * tileSize,
+				minY: ty * tileSize,
+				maxX: int(math.Min(float64((tx+1)*tileSize), float64(w))) - 1,
+				maxY: int(math.Min(float64((ty+1)*tileSize), float64(h))) - 1,
+			}})
+		}
+	}
+}
+
+// binTriangles adds each triangle to every tile its bounding box overlaps.
+// Triangles completely outside the colorbuffer are dropped.
+func (r *Renderer) binTriangles(triangles []Triangle) {
+	for i := range r.tiles {
+		r.tiles[i].triangles = r.tiles[i].triangles[:0]
+	}
+
+	maxX, maxY := r.window.bufferWidth-1, r.window.bufferHeight-1
+	for i, t := range triangles {
+		a, b, c := t.points[0], t.points[1], t.points[2]
+		minX := int(math.Floor(math.Min(a.x, math.Min(b.x, c.x))))
+		minY := int(math.Floor(math.Min(a.y, math.Min(b.y, c.y))))
+		maxTX := int(math.Ceil(math.Max(a.x, math.Max(b.x, c.x))))
+		maxTY := int(math.Ceil(math.Max(a.y, math.Max(b.y, c.y))))
+		if maxTX < 0 || maxTY < 0 || minX > maxX || minY > maxY {
+			continue
+		}
+
+		x0 := int(clamp(float64(minX), 0, float64(maxX))) / tileSize
+		y0 := int(clamp(float64(minY), 0, float64(maxY))) / tileSize
+		x1 := int(clamp(float64(maxTX), 0, float64(maxX))) / tileSize
+		y1 := int(clamp(float64(maxTY), 0, float64(maxY))) / tileSize
+		for ty := y0; ty <= y1; ty++ {
+			for tx := x0; tx <= x1; tx++ {
+				tile := &r.tiles[ty*r.tilesX+tx]
+				tile.triangles = append(tile.triangles, i)
+			}
+		}
+	}
+}
+
+// DrawTriangles draws the triangles in order, rasterizing the tiles of the
+// colorbuffer concurrently. The worker pool is sized to GOMAXPROCS.
+func (r *Renderer) DrawTriangles(triangles []Triangle, options RenderOptions) {
+	r.resizeTiles()
+	r.binTriangles(triangles)
+
+	jobs := make(chan int, len(r.tiles))
+	for i := range r.tiles {
+		if len(r.tiles[i].triangles) > 0 {
+			jobs <- i
+		}
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				tile := &r.tiles[i]
+				for _, index := range tile.triangles {
+					r.drawTriangle(triangles[index], tile.bounds, options)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,178 @@
+// This file contains the ability to decode PS1 TIM images.
+//
+// TIM layout:
+//
+//	bytes 0-3:  Magic (0x10)
+//	bytes 4-7:  Flags. Bits 0-2 are the pixel mode, bit 3 is set if there is a CLUT.
+//	            Pixel modes are 0: 4bpp, 1: 8bpp, 2: 16bpp, 3: 24bpp.
+//	CLUT block (optional):
+//	  bytes 0-3:  Length of the block, including this header
+//	  bytes 4-7:  X, Y in VRAM
+//	  bytes 8-11: Width (colors per row), height (rows)
+//	  bytes 12-:  RGB15 colors
+//	Image block:
+//	  bytes 0-3:  Length of the block, including this header
+//	  bytes 4-7:  X, Y in VRAM
+//	  bytes 8-11: Width in 16-bit units, height in pixels
+//	  bytes 12-:  Pixels
+//
+// The CLUT can hold more than one palette. 4bpp images use 16 colors per
+// palette and 8bpp images use 256, regardless of how the colors are laid out
+// in rows.
+//
+// Indexed images are decoded like map textures, where the R component of each
+// texel is the index into a palette. 16bpp and 24bpp images have no palettes
+// and the texels are the colors.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	timMagic     = 0x10
+	timHeaderLen = 8
+	timBlockLen  = 12
+	timFlagCLUT  = 0b1000
+)
+
+type TIM struct {
+	bpp     int
+	texture Texture
+	cluts   []Palette
+}
+
+// DecodeTIM decodes a TIM image.
+func DecodeTIM(data []byte) (TIM, error) {
+	if len(data) < timHeaderLen+timBlockLen || binary.LittleEndian.Uint32(data[0:4]) != timMagic {
+		return TIM{}, errors.New("not a TIM image")
+	}
+
+	flags := binary.LittleEndian.Uint32(data[4:8])
+	var tim TIM
+	switch flags & 0b111 {
+	case 0:
+		tim.bpp = 4
+	case 1:
+		tim.bpp = 8
+	case 2:
+		tim.bpp = 16
+	case 3:
+		tim.bpp = 24
+	default:
+		return TIM{}, fmt.Errorf("unsupported TIM pixel mode %d", flags&0b111)
+	}
+
+	f := MeshFile{data, timHeaderLen}
+
+	if flags&timFlagCLUT != 0 {
+		if int(f.offset)+timBlockLen > len(data) {
+			return TIM{}, errors.New("truncated TIM CLUT")
+		}
+		length := int64(f.readUint32())
+		f.readUint32() // VRAM position
+		width := int(f.readUint16())
+		height := int(f.readUint16())
+		end := f.offset - timBlockLen + length
+		if end > int64(len(data)) || int64(width*height*2) > length-timBlockLen {
+			return TIM{}, errors.New("truncated TIM CLUT")
+		}
+
+		colors := make([]Color, width*height)
+		for i := range colors {
+			colors[i] = f.readRGB15()
+		}
+
+		paletteLen := 16
+		if tim.bpp == 8 {
+			paletteLen = 256
+		}
+		for i := 0; i+paletteLen <= len(colors); i += paletteLen {
+			tim.cluts = append(tim.cluts, Palette(colors[i:i+paletteLen]))
+		}
+		f.seekPointer(end)
+	}
+
+	if (tim.bpp == 4 || tim.bpp == 8) && len(tim.cluts) == 0 {
+		return TIM{}, errors.New("indexed TIM without a CLUT")
+	}
+
+	if int(f.offset)+timBlockLen > len(data) {
+		return TIM{}, errors.New("truncated TIM image")
+	}
+	f.readUint32() // Length
+	f.readUint32() // VRAM position
+	rowLen := int(f.readUint16()) * 2
+	height := int(f.readUint16())
+	if int(f.offset)+rowLen*height > len(data) {
+		return TIM{}, errors.New("truncated TIM image")
+	}
+
+	width := rowLen * 8 / tim.bpp
+	pixels := make([]Color, 0, width*height)
+	for y := 0; y < height; y++ {
+		row := f.data[f.offset : f.offset+int64(rowLen)]
+		f.offset += int64(rowLen)
+
+		switch tim.bpp {
+		case 4:
+			pixels = append(pixels, splitPixels(row)...)
+		case 8:
+			for _, index := range row {
+				pixels = append(pixels, Color{R: index, G: index, B: index, A: 255})
+			}
+		case 16:
+			rf := MeshFile{row, 0}
+			for x := 0; x < width; x++ {
+				pixels = append(pixels, rf.readRGB15())
+			}
+		case 24:
+			rf := MeshFile{row, 0}
+			for x := 0; x < width; x++ {
+				pixels = append(pixels, rf.readRGB8())
+			}
+		}
+	}
+
+	tim.texture = NewTexture(width, height, pixels)
+	return tim, nil
+}
+
+// indexed returns true for 4bpp and 8bpp images, which use a CLUT.
+func (t TIM) indexed() bool {
+	return t.bpp == 4 || t.bpp == 8
+}
+
+// Texture returns the image as colors using a CLUT. The CLUT is ignored for
+// 16bpp and 24bpp images.
+func (t TIM) Texture(clut int) Texture {
+	if !t.indexed() || len(t.cluts) == 0 {
+		return t.texture
+	}
+	palette := t.cluts[clut%len(t.cluts)]
+
+	data := make([]Color, len(t.texture.data))
+	for i, texel := range t.texture.data {
+		data[i] = palette[texel.R]
+	}
+	return NewTexture(t.texture.width, t.texture.height, data)
+}
+
+// ReadTIM reads and decodes a TIM file from the disc, like "EVENT/TEST.TIM".
+func (r Reader) ReadTIM(path string) (TIM, error) {
+	entry, err := r.findFile(path)
+	if err != nil {
+		return TIM{}, err
+	}
+	data, err := r.readFile(entry.sector, entry.size)
+	if err != nil {
+		return TIM{}, err
+	}
+	tim, err := DecodeTIM(data)
+	if err != nil {
+		return TIM{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return tim, nil
+}
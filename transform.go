@@ -0,0 +1,297 @@
+// This file contains the vertex transform stage of Engine.update().
+//
+// The transform runs in two passes. First every unique vertex of the mesh is
+// transformed to world space, view space and projected to the screen. Then
+// every face looks up its transformed vertices, is lit, culled and turned into
+// a Triangle for the renderer. Both passes are split into chunks that are
+// processed on separate goroutines. The face chunks are concatenated in order
+// so the result doesn't depend on scheduling.
+//
+// The results of each model only depend on the matrices, the viewport size and
+// the mesh. When none of them have changed since the last frame the previous
+// results are reused.
+package main
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Smallest number of vertices or faces worth handing to a goroutine.
+const minTransformChunk = 256
+
+// nearPlane is the closest view space depth drawn with perspective projection.
+const nearPlane = 0.01
+
+// transformKey is everything the transform results depend on, other than the
+// mesh itself. Models are created with an empty key, so a new mesh is always
+// transformed.
+type transformKey struct {
+	world       Matrix
+	view        Matrix
+	projection  Matrix
+	perspective bool
+	width       int
+	height      int
+}
+
+// transformedVertex is a mesh vertex after the vertex pass.
+type transformedVertex struct {
+	world  Vec3 // World space, for lighting.
+	screen Vec2 // Screen space, in colorbuffer pixels.
+	depth  float64
+}
+
+// parallelChunks splits n items into chunks and calls fn for each chunk on its
+// own goroutine. It returns once all chunks are done.
+func parallelChunks(n int, fn func(chunk, lo, hi int)) int {
+	chunkSize := (n + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0)
+	if chunkSize < minTransformChunk {
+		chunkSize = minTransformChunk
+	}
+	numChunks := (n + chunkSize - 1) / chunkSize
+
+	var wg sync.WaitGroup
+	for i := 0; i < numChunks; i++ {
+		lo := i * chunkSize
+		hi := lo + chunkSize
+		if hi > n {
+			hi = n
+		}
+
+		wg.Add(1)
+		go func(i, lo, hi int) {
+			defer wg.Done()
+			fn(i, lo, hi)
+		}(i, lo, hi)
+	}
+	wg.Wait()
+	return numChunks
+}
+
+// transformKey returns the key for a world matrix with the current camera and
+// viewport.
+func (e *Engine) transformKey(world Matrix) transformKey {
+	return transformKey{
+		world:       world,
+		view:        e.camera.ViewMatrix(),
+		projection:  e.camera.ProjectionMatrix(),
+		perspective: e.camera.projection == Perspective,
+		width:       e.window.bufferWidth,
+		height:      e.window.bufferHeight,
+	}
+}
+
+// transformScene walks the scene and fills trianglesToRender with the visible
+// triangles of all models, sorted back to front.
+func (e *Engine) transformScene() {
+	changed := false
+	var models []*Model
+	e.scene.Walk(func(node *Node, world Matrix) {
+		model := node.model
+		if model == nil {
+			return
+		}
+		models = append(models, model)
+
+		model.UpdateMatrix(world)
+		if model.transform(e.transformKey(model.Matrix())) {
+			changed = true
+		}
+	})
+
+	// The scene structure can change without any model changing, such as when
+	// a node is removed, so the number of models is compared as well.
+	if !changed && len(models) == e.renderedModels {
+		return
+	}
+	e.renderedModels = len(models)
+
+	e.trianglesToRender = e.trianglesToRender[:0]
+	for _, model := range models {
+		e.trianglesToRender = append(e.trianglesToRender, model.trianglesToRender...)
+	}
+
+	// Sort the projected triangles front to back. The depthbuffer resolves
+	// visibility so the order only affects speed. Drawing the closest triangles
+	// first lets the depth test reject hidden pixels before they are textured.
+	sort.Slice(e.trianglesToRender, func(i, j int) bool {
+		return e.trianglesToRender[i].avgDepth < e.trianglesToRender[j].avgDepth
+	})
+}
+
+// transform fills trianglesToRender with the visible triangles of the model. It
+// returns false if nothing changed since the last call.
+func (m *Model) transform(key transformKey) bool {
+	if key == m.transformKey {
+		return false
+	}
+	m.transformKey = key
+
+	matrix := MatrixScale(m.mesh.scale)
+	lights := make([]DirectionalLight, len(m.mesh.directionalLights))
+	for i, light := range m.mesh.directionalLights {
+		light.position = matrix.MulVec3(light.position)
+		lights[i] = light
+	}
+
+	// Vertex pass
+	mesh := &m.mesh
+	if cap(m.vertices) < len(mesh.vertices) {
+		m.vertices = make([]transformedVertex, len(mesh.vertices))
+	}
+	m.vertices = m.vertices[:len(mesh.vertices)]
+	if cap(m.normals) < len(mesh.normals) {
+		m.normals = make([]Vec3, len(mesh.normals))
+	}
+	m.normals = m.normals[:len(mesh.normals)]
+
+	parallelChunks(len(mesh.vertices), func(_, lo, hi int) {
+		for i := lo; i < hi; i++ {
+			m.vertices[i] = transformVertex(mesh.vertices[i], key)
+		}
+	})
+
+	// Vertex normals are only rotated and scaled, never translated. A zero
+	// normal means the face has no vertex normals and stays zero.
+	for i, n := range mesh.normals {
+		if n != (Vec3{}) {
+			n = key.world.MulVec4(Vec4{n.x, n.y, n.z, 0}).Vec3().Normalize()
+		}
+		m.normals[i] = n
+	}
+
+	// Face pass
+	numChunks := (len(mesh.faces) + minTransformChunk - 1) / minTransformChunk
+	for len(m.chunks) < numChunks {
+		m.chunks = append(m.chunks, nil)
+	}
+	numChunks = parallelChunks(len(mesh.faces), func(chunk, lo, hi int) {
+		visible := m.chunks[chunk][:0]
+		for _, face := range mesh.faces[lo:hi] {
+			if t, ok := m.assembleTriangle(face, lights); ok {
+				visible = append(visible, t)
+			}
+		}
+		m.chunks[chunk] = visible
+	})
+
+	m.trianglesToRender = m.trianglesToRender[:0]
+	for _, chunk := range m.chunks[:numChunks] {
+		m.trianglesToRender = append(m.trianglesToRender, chunk...)
+	}
+	return true
+}
+
+// transformVertex transforms a vertex to world space, view space and projects
+// it to the screen.
+//
+// This is called concurrently so it must only read shared state.
+func transformVertex(vertex Vec3, key transformKey) transformedVertex {
+	var v transformedVertex
+
+	// Transform vertex with World Matrix
+	v.world = key.world.MulVec3(vertex)
+
+	// Depth is taken after the vertex is in view space.
+	view := key.view.MulVec3(v.world)
+	v.depth = view.z
+
+	// Projection
+	projected := key.projection.MulVec4(view.Vec4())
+
+	// Perspective divide is using perspective projection.
+	if key.perspective {
+		if projected.w != 0 {
+			projected.x /= projected.w
+			projected.y /= projected.w
+			projected.z /= projected.w
+		}
+	}
+
+	// Invert the Y asis to compensate for the Y axis of the model and
+	// the color buffer being different (+Y up vs +Y down, respectively).
+	projected.y *= -1
+
+	// Scale to the viewport. This is the colorbuffer size which
+	// is larger than the window when supersampling.
+	projected.x *= float64(key.width / 2)
+	projected.y *= float64(key.height / 2)
+
+	// Translate to center of screen
+	projected.x += float64(key.width / 2)
+	projected.y += float64(key.height / 2)
+
+	v.screen = Vec2{projected.x, projected.y}
+	return v
+}
+
+// depthValue returns the value stored in the depthbuffer for a view space depth.
+//
+// The depthbuffer values are interpolated linearly across the screen. That is
+// correct for view space depth with orthographic projection, but with
+// perspective projection only 1/z is linear in screen space. -1/z keeps smaller
+// values closer to the camera for both.
+func depthValue(z float64, perspective bool) float64 {
+	if perspective && z != 0 {
+		return -1 / z
+	}
+	return z
+}
+
+// assembleTriangle builds the lit and projected triangle for a face from the
+// transformed vertices. It returns false if the triangle is back-facing or
+// behind the near plane and should be culled.
+//
+// This is called concurrently so it must only read shared state.
+func (m *Model) assembleTriangle(face Face, lights []DirectionalLight) (Triangle, bool) {
+	a := m.vertices[face.vertices[0]]
+	b := m.vertices[face.vertices[1]]
+	c := m.vertices[face.vertices[2]]
+
+	// There is no clipping, so with perspective projection triangles that
+	// reach behind the near plane are dropped. Their projection would be
+	// flipped or stretched across the screen. This only happens when the
+	// camera is inside the map, like in free-fly mode.
+	if m.transformKey.perspective && (a.depth < nearPlane || b.depth < nearPlane || c.depth < nearPlane) {
+		return Triangle{}, false
+	}
+
+	points := [3]Vec2{a.screen, b.screen, c.screen}
+	if shouldCull(points) {
+		return Triangle{}, false
+	}
+
+	triangle := Triangle{
+		points:   points,
+		palette:  face.palette,
+		color:    face.color,
+		avgDepth: (a.depth + b.depth + c.depth) / 3.0,
+	}
+	perspective := m.transformKey.perspective
+	triangle.depths = [3]float64{
+		depthValue(a.depth, perspective),
+		depthValue(b.depth, perspective),
+		depthValue(c.depth, perspective),
+	}
+	if m.mesh.texture.data != nil {
+		triangle.texture = &m.mesh.texture
+	}
+
+	vertices := [3]Vec3{a.world, b.world, c.world}
+	normal := verticesNormal(vertices)
+	triangle.lightColor = lightColor(normal, vertices[0], lights, m.mesh.ambientLight)
+
+	// Faces without vertex normals fall back to the face normal.
+	for i := 0; i < 3; i++ {
+		n := m.normals[face.normals[i]]
+		if n == (Vec3{}) {
+			n = normal
+		}
+		triangle.lightColors[i] = lightColor(n, vertices[i], lights, m.mesh.ambientLight)
+		triangle.texcoords[i] = m.mesh.texcoords[face.texcoords[i]]
+	}
+
+	return triangle, true
+}
@@ -0,0 +1,298 @@
+// This file contains the differences between releases of the game.
+//
+// The PSX release is a raw bin file. The GNS file of each map is at a known
+// sector and its records reference the textures and meshes by sector.
+//
+// The PSP release (War of the Lions) is a 2048 byte sector ISO. Almost all of
+// the game files, including the maps, are packed into PSP_GAME/USRDIR/fftpack.bin.
+// The map files themselves use the same GNS, texture and mesh layouts.
+//
+// fftpack.bin layout:
+//
+//	bytes 0-3:  Number of files
+//	bytes 4-7:  Unused
+//	bytes 8-:   Byte offset of each file from the start of the archive
+//
+// A file ends where the next one starts.
+//
+// The archive has no file names and we don't have a table of which file is
+// which map, so the maps are found with a heuristic. It is based on the
+// assumption that the map files are packed in map order, the same way the PSX
+// release stores them in MAP/ by sector:
+//
+//   - GNS files are recognized by their content: a short list of known record
+//     types ending with an end record.
+//   - The n-th GNS file is the n-th map that exists on the PSX, so the maps
+//     that are missing there (120-124) are skipped.
+//   - A map's textures and meshes directly follow its GNS file, in the order
+//     of their PSX sectors. The records still hold the PSX sectors, so a record
+//     is resolved to a file by the rank of its sector among the sectors the map
+//     references.
+//
+// The pack is rejected unless it holds exactly one GNS file per PSX map, so a
+// different layout is an error instead of the wrong maps.
+//
+// Limitations: the heuristic has only been tested with synthetic archives,
+// not with a real War of the Lions image. Only the container is handled as a
+// difference between the releases. The GNS, texture and mesh files are read
+// with the PSX parsers, so any format changes in them are not handled. Files
+// that don't match the PSX layouts are reported as errors by the parsers, for
+// example a texture that is shorter than a PSX texture.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	pspPackPath = "PSP_GAME/USRDIR/fftpack.bin"
+
+	// GNS files are less than a sector, but can cross a sector boundary.
+	gnsMaxSectors = 2
+)
+
+// formatVariant reads the map files of one release of the game. The rest of
+// the parsing is shared.
+type formatVariant interface {
+	// Name of the release.
+	name() string
+
+	// hasMap returns true if the map exists in this release.
+	hasMap(mapNum int) bool
+
+	// readGNSRecords returns the GNS records of a map.
+	readGNSRecords(r Reader, mapNum int) ([]GNSRecord, error)
+
+	// readResource returns the texture or mesh file referenced by a GNS
+	// record of a map.
+	readResource(r Reader, mapNum int, record GNSRecord) ([]byte, error)
+}
+
+// detectVariant returns the release of the image. The sector layout must
+// already be known.
+func detectVariant(r Reader) (formatVariant, error) {
+	if pack, err := r.findFile(pspPackPath); err == nil {
+		v, err := newPSPVariant(r, pack)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pspPackPath, err)
+		}
+		return v, nil
+	}
+	return psxVariant{}, nil
+}
+
+//
+// PSX
+//
+
+type psxVariant struct{}
+
+func (psxVariant) name() string { return "PSX" }
+
+func (psxVariant) hasMap(mapNum int) bool {
+	return mapNum >= 0 && mapNum < len(GNSSectors) && GNSSectors[mapNum] != 0
+}
+
+func (v psxVariant) readGNSRecords(r Reader, mapNum int) ([]GNSRecord, error) {
+	if !v.hasMap(mapNum) {
+		return nil, fmt.Errorf("map %d has no GNS file", mapNum)
+	}
+
+	// The records can cross a sector boundary, so read sectors until the
+	// end record is found.
+	sector := GNSSectors[mapNum]
+	data := []byte{}
+	for i := int64(0); i < gnsMaxSectors; i++ {
+		sectorData, err := r.readSector(sector + i)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sectorData...)
+		if records, ok := parseGNSRecords(data); ok {
+			return records, nil
+		}
+	}
+	return nil, fmt.Errorf("map %d: GNS file has no end record", mapNum)
+}
+
+func (psxVariant) readResource(r Reader, mapNum int, record GNSRecord) ([]byte, error) {
+	return r.readFile(record.Sector(), record.Len())
+}
+
+//
+// PSP
+//
+
+type pspVariant struct {
+	pack    isoEntry
+	offsets []int64
+	maps    map[int]pspMap
+}
+
+// pspMap is where the files of a map are in the archive.
+type pspMap struct {
+	gns       int           // Archive index of the GNS file
+	resources map[int64]int // Archive index of each PSX sector in the records
+}
+
+func newPSPVariant(r Reader, pack isoEntry) (*pspVariant, error) {
+	v := &pspVariant{pack: pack, maps: make(map[int]pspMap)}
+
+	header, err := v.readAt(r, 0, 8)
+	if err != nil {
+		return nil, err
+	}
+	count := int64(binary.LittleEndian.Uint32(header[0:4]))
+	if 8+count*4 > pack.size {
+		return nil, fmt.Errorf("%d files don't fit in %d bytes", count, pack.size)
+	}
+	table, err := v.readAt(r, 8, count*4)
+	if err != nil {
+		return nil, err
+	}
+	v.offsets = make([]int64, count)
+	for i := range v.offsets {
+		v.offsets[i] = int64(binary.LittleEndian.Uint32(table[i*4:]))
+		if v.offsets[i] < 8+count*4 || v.offsets[i] > pack.size || i > 0 && v.offsets[i] < v.offsets[i-1] {
+			return nil, fmt.Errorf("invalid offset %d of file %d", v.offsets[i], i)
+		}
+	}
+
+	// Number the GNS files with the maps that exist on the PSX. See the top
+	// of the file.
+	mapNums := []int{}
+	for n := range GNSSectors {
+		if GNSSectors[n] != 0 {
+			mapNums = append(mapNums, n)
+		}
+	}
+	found := 0
+	for i := range v.offsets {
+		records, err := v.readGNS(r, i)
+		if err != nil {
+			return nil, err
+		}
+		if records == nil {
+			continue
+		}
+		if found < len(mapNums) {
+			m, err := v.newPSPMap(i, records)
+			if err != nil {
+				return nil, fmt.Errorf("map %d: %w", mapNums[found], err)
+			}
+			v.maps[mapNums[found]] = m
+		}
+		found++
+	}
+	if found != len(mapNums) {
+		return nil, fmt.Errorf("found %d GNS files instead of %d, unknown archive layout", found, len(mapNums))
+	}
+	return v, nil
+}
+
+// newPSPMap returns the files of the map with the GNS file at index. The
+// resources follow the GNS file in the order of their PSX sectors.
+func (v *pspVariant) newPSPMap(index int, records []GNSRecord) (pspMap, error) {
+	sectors := []int64{}
+	seen := map[int64]bool{}
+	for _, record := range records {
+		if !seen[record.Sector()] {
+			seen[record.Sector()] = true
+			sectors = append(sectors, record.Sector())
+		}
+	}
+	sort.Slice(sectors, func(i, j int) bool { return sectors[i] < sectors[j] })
+
+	m := pspMap{gns: index, resources: make(map[int64]int)}
+	for rank, sector := range sectors {
+		if index+1+rank >= len(v.offsets) {
+			return pspMap{}, errors.New("resources past the end of the archive")
+		}
+		m.resources[sector] = index + 1 + rank
+	}
+	return m, nil
+}
+
+func (*pspVariant) name() string { return "PSP" }
+
+// readAt reads size bytes at offset from the start of the archive. The PSP
+// image has no sector headers, so the archive is contiguous in the image.
+func (v *pspVariant) readAt(r Reader, offset, size int64) ([]byte, error) {
+	if offset < 0 || size < 0 || offset+size > v.pack.size {
+		return nil, fmt.Errorf("read of %d bytes at %d is outside the archive", size, offset)
+	}
+	data := make([]byte, size)
+	if n, err := r.data.ReadAt(data, v.pack.sector*sectorSize+offset); int64(n) != size {
+		if err == nil {
+			err = errors.New("short read")
+		}
+		return nil, fmt.Errorf("truncated archive: %w", err)
+	}
+	return data, nil
+}
+
+// fileSize returns the size of a file in the archive.
+func (v *pspVariant) fileSize(index int) int64 {
+	end := v.pack.size
+	if index+1 < len(v.offsets) {
+		end = v.offsets[index+1]
+	}
+	return end - v.offsets[index]
+}
+
+func (v *pspVariant) readPackFile(r Reader, index int) ([]byte, error) {
+	if index < 0 || index >= len(v.offsets) {
+		return nil, fmt.Errorf("no file %d in the archive", index)
+	}
+	return v.readAt(r, v.offsets[index], v.fileSize(index))
+}
+
+// readGNS returns the records of a file in the archive if it looks like a GNS
+// file, or nil. GNS files are small and are a list of known record types
+// ending with an end record.
+func (v *pspVariant) readGNS(r Reader, index int) ([]GNSRecord, error) {
+	size := v.fileSize(index)
+	if size < GNSRecordLen || size > gnsMaxSectors*sectorSize {
+		return nil, nil
+	}
+	data, err := v.readPackFile(r, index)
+	if err != nil {
+		return nil, err
+	}
+	records, ok := parseGNSRecords(data)
+	if !ok || len(records) == 0 {
+		return nil, nil
+	}
+	for _, record := range records {
+		if !record.Type().known() {
+			return nil, nil
+		}
+	}
+	return records, nil
+}
+
+func (v *pspVariant) hasMap(mapNum int) bool {
+	_, ok := v.maps[mapNum]
+	return ok
+}
+
+func (v *pspVariant) readGNSRecords(r Reader, mapNum int) ([]GNSRecord, error) {
+	m, ok := v.maps[mapNum]
+	if !ok {
+		return nil, fmt.Errorf("map %d has no GNS file", mapNum)
+	}
+	return v.readGNS(r, m.gns)
+}
+
+func (v *pspVariant) readResource(r Reader, mapNum int, record GNSRecord) ([]byte, error) {
+	index, ok := v.maps[mapNum].resources[record.Sector()]
+	if !ok {
+		return nil, fmt.Errorf("map %d has no resource at sector %d", mapNum, record.Sector())
+	}
+	// The length in the record is the PSX length, so the whole file is
+	// returned.
+	return v.readPackFile(r, index)
+}
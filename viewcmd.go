@@ -0,0 +1,368 @@
+// This file contains the view and render commands.
+//
+//	go run *.go [view] [flags] [path-to-bin]
+//	go run *.go render [flags] <path-to-bin> <output.png>
+//
+// Both take the same flags for the map, scenario, size, projection and render
+// options, so a map can be rendered to a file the way it is shown in the
+// viewer. Flags can be written with one or two dashes, like -map or --map.
+//
+// The viewer flags default to the config file, see config.go. The render
+// command always starts from the built in defaults.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+)
+
+// viewConfig is how the viewer starts, or how a map is rendered.
+type viewConfig struct {
+	mapNum     int
+	scenario   int
+	width      int
+	height     int
+	fullscreen bool
+	projection string
+	quadrant   int // Of the game camera, or -1 for the free camera.
+	tilt       string
+	obj        string
+
+	options    RenderOptions
+	ssaa       int
+	autorotate bool
+	background bool
+	hud        bool
+}
+
+func defaultViewConfig() viewConfig {
+	return viewConfig{
+		mapNum:     49,
+		width:      windowWidth,
+		height:     windowHeight,
+		projection: "orthographic",
+		quadrant:   -1,
+		tilt:       "low",
+		options:    DefaultRenderOptions(),
+		ssaa:       1,
+		hud:        true,
+	}
+}
+
+// viewFlags defines the flags of the view and render commands.
+func viewFlags(fs *flag.FlagSet, defaults viewConfig) *viewConfig {
+	c := &viewConfig{}
+
+	fs.IntVar(&c.mapNum, "map", defaults.mapNum, "map number")
+	fs.IntVar(&c.scenario, "scenario", defaults.scenario, "scenario of the map, in the order listed by the info command")
+	fs.IntVar(&c.width, "width", defaults.width, "width in pixels")
+	fs.IntVar(&c.height, "height", defaults.height, "height in pixels")
+	fs.BoolVar(&c.fullscreen, "fullscreen", defaults.fullscreen, "fullscreen at half the display resolution (view only)")
+	fs.StringVar(&c.projection, "projection", defaults.projection, "orthographic or perspective")
+	fs.IntVar(&c.quadrant, "quadrant", defaults.quadrant, "use the game camera from quadrant 0 to 3, or -1 for the free camera")
+	fs.StringVar(&c.tilt, "tilt", defaults.tilt, "tilt of the game camera, low or high")
+	fs.StringVar(&c.obj, "obj", "", "wavefront obj file to add to the scene (view only)")
+
+	fs.BoolVar(&c.options.showTexture, "texture", defaults.options.showTexture, "show textures")
+	fs.BoolVar(&c.options.showLighting, "lighting", defaults.options.showLighting, "light the map")
+	fs.BoolVar(&c.options.showWireframe, "wireframe", defaults.options.showWireframe, "draw the wireframe")
+	fs.BoolVar(&c.options.bilinearFilter, "filter", defaults.options.bilinearFilter, "bilinear texture filtering")
+	fs.BoolVar(&c.options.smoothShading, "gouraud", defaults.options.smoothShading, "gouraud shading")
+	fs.IntVar(&c.ssaa, "ssaa", defaults.ssaa, fmt.Sprintf("supersampling factor, 1 (off) to %d", maxSupersampling))
+	fs.BoolVar(&c.autorotate, "autorotate", defaults.autorotate, "rotate the map (view only)")
+	fs.BoolVar(&c.background, "background", defaults.background, "use the map background instead of the checkerboard or transparency")
+	fs.BoolVar(&c.hud, "hud", defaults.hud, "show the help overlay (view only)")
+	return c
+}
+
+func (c *viewConfig) cameraProjection() (Projection, error) {
+	switch c.projection {
+	case "orthographic", "ortho":
+		return Orthographic, nil
+	case "perspective":
+		return Perspective, nil
+	}
+	return Orthographic, fmt.Errorf("unknown projection %q", c.projection)
+}
+
+// checkOptions returns an error if the options are invalid. Unlike check, it
+// doesn't need the disc.
+func (c *viewConfig) checkOptions() error {
+	if _, err := c.cameraProjection(); err != nil {
+		return err
+	}
+	if c.quadrant < -1 || c.quadrant >= gameCameraQuadrants {
+		return fmt.Errorf("quadrant must be -1 to %d", gameCameraQuadrants-1)
+	}
+	if c.tilt != "low" && c.tilt != "high" {
+		return fmt.Errorf("unknown tilt %q", c.tilt)
+	}
+	if c.ssaa < 1 || c.ssaa > maxSupersampling {
+		return fmt.Errorf("supersampling must be 1 to %d", maxSupersampling)
+	}
+	if c.width <= 0 || c.height <= 0 {
+		return fmt.Errorf("invalid size %dx%d", c.width, c.height)
+	}
+	return nil
+}
+
+// check returns an error if the flags are invalid for the disc.
+func (c *viewConfig) check(r Reader) error {
+	if err := c.checkOptions(); err != nil {
+		return err
+	}
+	if !r.HasMap(c.mapNum) {
+		return fmt.Errorf("map %d is not on the disc", c.mapNum)
+	}
+	scenarios, err := r.ReadScenarios(c.mapNum)
+	if err != nil {
+		return err
+	}
+	if len(scenarios) == 0 {
+		return fmt.Errorf("map %d has no scenarios", c.mapNum)
+	}
+	if n := len(scenarios); c.scenario < 0 || c.scenario >= n {
+		return fmt.Errorf("map %d has scenarios 0 to %d", c.mapNum, n-1)
+	}
+	return nil
+}
+
+// apply sets the starting options of the viewer.
+func (c *viewConfig) apply(e *Engine) {
+	e.options = c.options
+	e.autorotate = c.autorotate
+	e.showHelp = c.hud
+	e.showMapBackground = c.background
+	e.window.SetSupersampling(c.ssaa)
+
+	e.camera.projection, _ = c.cameraProjection()
+	e.camera.updateProjectionMatrix()
+	if c.quadrant >= 0 {
+		e.startGameCamera(c.quadrant, c.tilt == "high")
+	}
+
+	if c.obj != "" {
+		if _, err := e.loadObj(c.obj); err != nil {
+			fmt.Fprintln(os.Stderr, "load obj:", err)
+		}
+	}
+}
+
+// runView runs the viewer. The config file is written when the viewer exits,
+// unless it couldn't be read. Then it is left for the user to fix.
+func runView(args []string) error {
+	// A config file that can't be read or has an invalid value is ignored
+	// and not overwritten, so it can be fixed.
+	saved, err := LoadConfig()
+	if err == nil {
+		err = saved.check()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		fmt.Fprintln(os.Stderr, "config: using the defaults, the config file will not be changed")
+		saved = newConfig(defaultViewConfig(), defaultBindings)
+	}
+	saveConfig := err == nil
+	bindings, err := saved.bindings()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	fs := flag.NewFlagSet("view", flag.ExitOnError)
+	config := viewFlags(fs, saved.view())
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go run *.go [view] [flags] [path-to-bin]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	path, err := getPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	reader, err := NewReader(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := config.check(*reader); err != nil {
+		// The last map of the config file may not be on this disc.
+		explicit := false
+		fs.Visit(func(f *flag.Flag) {
+			explicit = explicit || f.Name == "map" || f.Name == "scenario"
+		})
+		if explicit {
+			return err
+		}
+		config.mapNum, config.scenario = defaultViewConfig().mapNum, 0
+		if err := config.check(*reader); err != nil {
+			return err
+		}
+	}
+
+	var window *Window
+	if config.fullscreen {
+		window = NewWindowFullscreen()
+	} else {
+		window = NewWindow(config.width, config.height)
+	}
+	defer window.Close()
+	renderer := NewRenderer(window)
+
+	engine := NewEngine(window, renderer, reader)
+	engine.setBindings(bindings)
+	config.apply(engine)
+	if config.mapNum == saved.Last.Map && config.scenario == saved.Last.Scenario {
+		engine.restoreCamera = saved.Last.Camera
+	}
+	engine.setMap(config.mapNum, config.scenario)
+
+	engine.setup()
+	for engine.isRunning {
+		engine.processInput()
+		engine.update()
+		engine.render()
+	}
+
+	session := engine.config(config.fullscreen)
+	if engine.currentMap == 0 {
+		// Closed before the first map was shown.
+		session.Last = saved.Last
+	}
+	if config.fullscreen {
+		// The fullscreen size depends on the display. Keep the window size.
+		session.Window.Width, session.Window.Height = config.width, config.height
+	}
+	if !saveConfig {
+		return nil
+	}
+	if err := SaveConfig(session); err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+	}
+	return nil
+}
+
+// runRender renders a map to a PNG without opening a window.
+func runRender(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	config := viewFlags(fs, defaultViewConfig())
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go run *.go render [flags] <path-to-bin> <output.png>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	reader, err := NewReader(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := config.check(*reader); err != nil {
+		return err
+	}
+
+	mesh, err := reader.ReadMesh(config.mapNum, config.scenario)
+	if err != nil {
+		return err
+	}
+	projection, _ := config.cameraProjection()
+	eye := defaultEyeOffset
+	if config.quadrant >= 0 {
+		// The game camera is always orthographic.
+		projection = Orthographic
+		eye = gameEyeOffset(config.quadrant, config.tilt == "high", defaultEyeOffset.Length())
+	}
+	texture := renderMesh(mesh, config.width, config.height, projection, eye, config.options, config.ssaa)
+
+	if config.background {
+		for y := 0; y < texture.height; y++ {
+			bg := mesh.background.At(texture.height-y-1, texture.height)
+			for x := 0; x < texture.width; x++ {
+				c := &texture.data[y*texture.width+x]
+				a := float64(c.A) / 255
+				*c = interpolateColor(*c, bg, Black, a, 1-a, 0)
+				c.A = 255
+			}
+		}
+	}
+
+	if err := writePNG(fs.Arg(1), texture.Image()); err != nil {
+		return err
+	}
+	fmt.Println("rendered", fs.Arg(1))
+	return nil
+}
+
+// renderMesh renders a mesh into a texture, looking from the direction of the
+// eye offset and fit to the texture like in the viewer. Empty pixels are
+// transparent.
+func renderMesh(mesh Mesh, width, height int, projection Projection, eye Vec3, options RenderOptions, ssaa int) Texture {
+	window := NewOffscreenWindow(width, height)
+	window.SetSupersampling(ssaa)
+	renderer := NewRenderer(window)
+
+	center := mesh.coordCenter().Mul(modelScale)
+	camera := NewCamera(center.Add(eye), center, Vec3{0, 1, 0}, width, height)
+	camera.projection = projection
+	camera.updateProjectionMatrix()
+	model := NewModel(mesh)
+	eye, front, zoom := camera.Fit(mesh.bounds().Transform(model.Matrix()), mesh.boundingSphere().Transform(model.Matrix()))
+	camera.eye, camera.front, camera.zoom = eye, front, zoom
+	camera.updateProjectionMatrix()
+	camera.updateViewMatrix()
+
+	model.transform(transformKey{
+		world:       model.Matrix(),
+		view:        camera.ViewMatrix(),
+		projection:  camera.ProjectionMatrix(),
+		perspective: projection == Perspective,
+		width:       window.bufferWidth,
+		height:      window.bufferHeight,
+	})
+	triangles := model.trianglesToRender
+	sort.Slice(triangles, func(i, j int) bool {
+		return triangles[i].avgDepth < triangles[j].avgDepth
+	})
+	renderer.DrawTriangles(triangles, options)
+
+	if options.showWireframe {
+		for _, t := range triangles {
+			t.color = Magenta
+			renderer.DrawTriangle(t)
+		}
+	}
+
+	if ssaa > 1 {
+		window.downsample()
+		return NewTexture(width, height, window.framebuffer)
+	}
+	return NewTexture(width, height, window.colorbuffer)
+}
+
+// getPath returns the path of the bin file, or the default path if path is
+// empty.
+func getPath(path string) (string, error) {
+	if path == "" {
+		usr, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(usr.HomeDir, "media", "emu", "fft.bin")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
@@ -1,89 +1,226 @@
-// This file is for loading wavefront obj files as meshes.
+// This file is for loading wavefront obj files as meshes and writing meshes as
+// wavefront obj files.
 package main
 
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
-func NewMeshFromObj(objFilename string) Mesh {
+// NewMeshFromObj reads a wavefront obj file. Face vertices may be in any of the
+// v, v/vt, v//vn and v/vt/vn forms, and polygons with more than three vertices
+// are split into triangles.
+//
+// Obj files have no lights. The mesh is unlit, like sprites, until it is given
+// lights.
+func NewMeshFromObj(objFilename string) (Mesh, error) {
 	objFile, err := os.Open(objFilename)
 	if err != nil {
-		panic(err)
+		return Mesh{}, err
 	}
 	defer objFile.Close()
 
 	mesh := NewMesh()
+	mesh.ambientLight = AmbientLight{color: White}
+	builder := newMeshBuilder(&mesh)
 
 	vertices := []Vec3{}
 	var vts []Tex
+	var vns []Vec3
 
 	scanner := bufio.NewScanner(objFile)
-	for scanner.Scan() {
+	for lineNum := 1; scanner.Scan(); lineNum++ {
 		line := scanner.Text()
 		switch {
 		case strings.HasPrefix(line, "v "):
 			var v Vec3
 			matches, err := fmt.Fscanf(strings.NewReader(line), "v %f %f %f", &v.x, &v.y, &v.z)
 			if err != nil || matches != 3 {
-				log.Fatalf("vertex: only %d matches on line %q\n", matches, line)
+				return Mesh{}, fmt.Errorf("%s:%d: invalid vertex %q", objFilename, lineNum, line)
 			}
 			vertices = append(vertices, v)
 		case strings.HasPrefix(line, "vt "):
 			var vt Tex
 			matches, err := fmt.Fscanf(strings.NewReader(line), "vt %f %f", &vt.u, &vt.v)
 			if err != nil || matches != 2 {
-				log.Fatalf("vertex: only %d matches on line %q\n", matches, line)
+				return Mesh{}, fmt.Errorf("%s:%d: invalid texcoord %q", objFilename, lineNum, line)
 			}
 			vt.v = 1 - vt.v
 			vts = append(vts, vt)
+		case strings.HasPrefix(line, "vn "):
+			var vn Vec3
+			matches, err := fmt.Fscanf(strings.NewReader(line), "vn %f %f %f", &vn.x, &vn.y, &vn.z)
+			if err != nil || matches != 3 {
+				return Mesh{}, fmt.Errorf("%s:%d: invalid normal %q", objFilename, lineNum, line)
+			}
+			vns = append(vns, vn)
 		case strings.HasPrefix(line, "f "):
-			var vertexIndices [3]int
-			var normalIndices [3]int
-			var textureIndices [3]int
-			f := strings.NewReader(line)
-
-			if !strings.Contains(line, "/") {
-				matches, err := fmt.Fscanf(f, "f %d %d %d", &vertexIndices[0], &vertexIndices[1], &vertexIndices[2])
-				if err != nil || matches != 3 {
-					log.Fatalf("face: only %d matches on line %q\n", matches, line)
-				}
-
-				// Append Face
-			} else {
-				matches, err := fmt.Fscanf(f, "f %d/%d/%d %d/%d/%d %d/%d/%d",
-					&vertexIndices[0], &textureIndices[0], &normalIndices[0],
-					&vertexIndices[1], &textureIndices[1], &normalIndices[1],
-					&vertexIndices[2], &textureIndices[2], &normalIndices[2],
-				)
-				if err != nil || matches != 9 {
-					log.Fatalf("face: only %d matches on line %q\n", matches, line)
+			fields := strings.Fields(line)[1:]
+			if len(fields) < 3 {
+				return Mesh{}, fmt.Errorf("%s:%d: face with less than 3 vertices", objFilename, lineNum)
+			}
+			corners := make([]objCorner, len(fields))
+			for i, field := range fields {
+				corner, err := parseObjCorner(field, len(vertices), len(vts), len(vns))
+				if err != nil {
+					return Mesh{}, fmt.Errorf("%s:%d: %w", objFilename, lineNum, err)
 				}
+				corners[i] = corner
 			}
 
-			triangle := Triangle{
-				color: White,
-				texcoords: [3]Tex{
-					vts[textureIndices[0]-1],
-					vts[textureIndices[1]-1],
-					vts[textureIndices[2]-1],
-				},
-				vertices: [3]Vec3{
-					vertices[vertexIndices[0]-1],
-					vertices[vertexIndices[1]-1],
-					vertices[vertexIndices[2]-1],
-				},
+			// Split polygons into a fan of triangles.
+			for i := 1; i+1 < len(corners); i++ {
+				var face [3]Vec3
+				var texcoords [3]Tex
+				var normals [3]Vec3
+				for j, corner := range [3]objCorner{corners[0], corners[i], corners[i+1]} {
+					face[j] = vertices[corner.vertex-1]
+					if corner.texcoord > 0 {
+						texcoords[j] = vts[corner.texcoord-1]
+					}
+					if corner.normal > 0 {
+						normals[j] = vns[corner.normal-1]
+					}
+				}
+				builder.addFace(face, normals, texcoords, nil, White)
 			}
-			mesh.triangles = append(mesh.triangles, triangle)
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return Mesh{}, err
+	}
+
+	return mesh, nil
+}
+
+// objCorner is a vertex of a face. Indices are 1-based. Zero means the face
+// doesn't reference any texcoords or normals.
+type objCorner struct {
+	vertex, texcoord, normal int
+}
+
+// parseObjCorner parses a face vertex like "1", "1/2", "1//3" or "1/2/3". The
+// indices are checked against the number of vertices, texcoords and normals
+// read so far.
+func parseObjCorner(field string, vertices, texcoords, normals int) (objCorner, error) {
+	parts := strings.Split(field, "/")
+	if len(parts) > 3 {
+		return objCorner{}, fmt.Errorf("invalid face vertex %q", field)
+	}
+	var indices [3]int
+	counts := [3]int{vertices, texcoords, normals}
+	for i, part := range parts {
+		if part == "" && i > 0 {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 || n > counts[i] {
+			return objCorner{}, fmt.Errorf("invalid face vertex %q", field)
+		}
+		indices[i] = n
+	}
+	return objCorner{indices[0], indices[1], indices[2]}, nil
+}
+
+// WriteObj writes a mesh to dir as name.obj with a name.mtl material library.
+//
+// Textured faces get a material per palette, with the texture resolved through
+// that palette written next to it as a PNG. Untextured faces get a material per
+// color. Texcoords are flipped to the obj convention so NewMeshFromObj reads
+// the same mesh back, with the scale applied to the vertices.
+func WriteObj(mesh Mesh, dir, name string) error {
+	obj, err := os.Create(filepath.Join(dir, name+".obj"))
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	w := bufio.NewWriter(obj)
+
+	fmt.Fprintf(w, "mtllib %s.mtl\n", name)
+	// The vertices are scaled like when the map is drawn, so maps have the
+	// same size as in the viewer. Normals are unchanged by the uniform scale.
+	for _, v := range mesh.vertices {
+		fmt.Fprintf(w, "v %f %f %f\n", v.x*mesh.scale.x, v.y*mesh.scale.y, v.z*mesh.scale.z)
+	}
+	for _, vt := range mesh.texcoords {
+		fmt.Fprintf(w, "vt %f %f\n", vt.u, 1-vt.v)
 	}
+	for _, vn := range mesh.normals {
+		fmt.Fprintf(w, "vn %f %f %f\n", vn.x, vn.y, vn.z)
+	}
+
+	// Faces are grouped by material so each usemtl is written once.
+	var materials []objMaterial
+	faces := map[objMaterial][]Face{}
+	for _, face := range mesh.faces {
+		material := objMaterial{color: face.color}
+		if face.palette != nil && mesh.texture.data != nil {
+			material = objMaterial{palette: &face.palette[0]}
+		}
+		if _, ok := faces[material]; !ok {
+			materials = append(materials, material)
+		}
+		faces[material] = append(faces[material], face)
+	}
+
+	for i, material := range materials {
+		fmt.Fprintf(w, "usemtl %s\n", material.name(name, i))
+		for _, f := range faces[material] {
+			fmt.Fprintf(w, "f %d/%d/%d %d/%d/%d %d/%d/%d\n",
+				f.vertices[0]+1, f.texcoords[0]+1, f.normals[0]+1,
+				f.vertices[1]+1, f.texcoords[1]+1, f.normals[1]+1,
+				f.vertices[2]+1, f.texcoords[2]+1, f.normals[2]+1)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := obj.Close(); err != nil {
+		return err
+	}
+
+	mtl, err := os.Create(filepath.Join(dir, name+".mtl"))
+	if err != nil {
+		return err
+	}
+	defer mtl.Close()
+	w = bufio.NewWriter(mtl)
 
-	return mesh
+	for i, material := range materials {
+		fmt.Fprintf(w, "newmtl %s\n", material.name(name, i))
+		if material.palette != nil {
+			texture := material.name(name, i) + ".png"
+			palette := faces[material][0].palette
+			fmt.Fprintf(w, "Kd 1 1 1\nmap_Kd %s\n\n", texture)
+			if err := writePNG(filepath.Join(dir, texture), mesh.texture.resolve(palette).Image()); err != nil {
+				return err
+			}
+		} else {
+			c := material.color
+			fmt.Fprintf(w, "Kd %f %f %f\n\n", float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return mtl.Close()
+}
+
+// objMaterial is a material of an exported mesh. Palettes are compared by
+// their first color because faces share the palette slices of their mesh.
+type objMaterial struct {
+	palette *Color // Textured faces
+	color   Color  // Untextured faces
+}
+
+func (m objMaterial) name(prefix string, index int) string {
+	if m.palette != nil {
+		return fmt.Sprintf("%s_texture%d", prefix, index)
+	}
+	return fmt.Sprintf("%s_color%d", prefix, index)
 }
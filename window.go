@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math"
 	"unsafe"
 
 	"github.com/veandco/go-sdl2/sdl"
@@ -16,6 +17,13 @@ type Window struct {
 	width  int
 	height int
 
+	// Supersampling factor and the size of the colorbuffer. The renderer draws
+	// into a colorbuffer ssaa times the window size which is downsampled into
+	// the framebuffer during Present().
+	ssaa         int
+	bufferWidth  int
+	bufferHeight int
+
 	window    *sdl.Window
 	renderer  *sdl.Renderer
 	fgTexture *sdl.Texture // Texture for colorbuffer
@@ -23,6 +31,8 @@ type Window struct {
 	font      *ttf.Font
 
 	colorbuffer  []Color
+	depthbuffer  []float64     // Same size as the colorbuffer. Smaller is closer.
+	framebuffer  []Color       // Downsampled colorbuffer when supersampling
 	textTextures []TextTexture // Static texture for background
 }
 
@@ -99,6 +109,10 @@ func newWindow(width, height int, fullscreen bool) *Window {
 		width:  width,
 		height: height,
 
+		ssaa:         1,
+		bufferWidth:  width,
+		bufferHeight: height,
+
 		window:    window,
 		renderer:  renderer,
 		fgTexture: fgTexture,
@@ -106,11 +120,33 @@ func newWindow(width, height int, fullscreen bool) *Window {
 		font:      font,
 
 		colorbuffer: make([]Color, width*height),
+		depthbuffer: make([]float64, width*height),
+		framebuffer: make([]Color, width*height),
 	}
+	w.Clear(Transparent)
 	w.SetDefaultBackground()
 	return &w
 }
 
+// NewOffscreenWindow returns a window that only has buffers. It is used to
+// render into memory, like map thumbnails. It can't be presented.
+func NewOffscreenWindow(width, height int) *Window {
+	w := Window{
+		width:  width,
+		height: height,
+
+		ssaa:         1,
+		bufferWidth:  width,
+		bufferHeight: height,
+
+		colorbuffer: make([]Color, width*height),
+		depthbuffer: make([]float64, width*height),
+		framebuffer: make([]Color, width*height),
+	}
+	w.Clear(Transparent)
+	return &w
+}
+
 func (w *Window) SetTitle(title string) {
 	w.window.SetTitle(title)
 }
@@ -154,25 +190,105 @@ func (w *Window) TextBackground(width, height int, color Color) {
 	w.textTextures = append(w.textTextures, TextTexture{texture, rect})
 }
 
+// SetSupersampling sets the supersampling factor. A factor of 1 disables
+// supersampling. The colorbuffer is reallocated to the new size.
+func (w *Window) SetSupersampling(factor int) {
+	if factor < 1 {
+		factor = 1
+	}
+	w.ssaa = factor
+	w.bufferWidth = w.width * factor
+	w.bufferHeight = w.height * factor
+	w.colorbuffer = make([]Color, w.bufferWidth*w.bufferHeight)
+	w.depthbuffer = make([]float64, w.bufferWidth*w.bufferHeight)
+	w.Clear(Transparent)
+}
+
+// SetPixel sets a pixel in the colorbuffer. The coordinates are in colorbuffer
+// space, which is larger than the window when supersampling.
 func (w *Window) SetPixel(x, y int, color Color) {
-	if x < 0 || x >= w.width || y < 0 || y >= w.height {
+	if x < 0 || x >= w.bufferWidth || y < 0 || y >= w.bufferHeight {
+		return
+	}
+	w.colorbuffer[(w.bufferWidth*y)+x] = color
+}
+
+// DepthTest returns true if depth is closer than the depth already drawn at
+// x, y.
+func (w *Window) DepthTest(x, y int, depth float64) bool {
+	if x < 0 || x >= w.bufferWidth || y < 0 || y >= w.bufferHeight {
+		return false
+	}
+	return depth < w.depthbuffer[(w.bufferWidth*y)+x]
+}
+
+// SetPixelDepth sets a pixel in the colorbuffer and its depth in the
+// depthbuffer. The depth should be checked with DepthTest first.
+func (w *Window) SetPixelDepth(x, y int, depth float64, color Color) {
+	if x < 0 || x >= w.bufferWidth || y < 0 || y >= w.bufferHeight {
 		return
 	}
-	w.colorbuffer[(w.width*y)+x] = color
+	w.colorbuffer[(w.bufferWidth*y)+x] = color
+	w.depthbuffer[(w.bufferWidth*y)+x] = depth
 }
 
+// Clear clears the colorbuffer to color and the depthbuffer to the far plane.
 func (w *Window) Clear(color Color) {
 	// Write directly to the buffer because the range checks are not
 	// necessary.
+	for i := range w.colorbuffer {
+		w.colorbuffer[i] = color
+	}
+	for i := range w.depthbuffer {
+		w.depthbuffer[i] = math.Inf(1)
+	}
+}
+
+// downsample reduces the supersampled colorbuffer to the window size with a box
+// filter. Each output pixel is the average of the ssaa*ssaa block it covers.
+//
+// The colorbuffer is cleared to transparent so the background can show
+// through. The colors are weighted by alpha so the transparent black samples
+// don't darken the edges of the map.
+func (w *Window) downsample() {
+	n := w.ssaa
+	samples := float64(n * n)
 	for y := 0; y < w.height; y++ {
 		for x := 0; x < w.width; x++ {
-			w.colorbuffer[(w.width*y)+x] = color
+			var r, g, b, a float64
+			for sy := 0; sy < n; sy++ {
+				row := (y*n + sy) * w.bufferWidth
+				for sx := 0; sx < n; sx++ {
+					c := w.colorbuffer[row+x*n+sx]
+					ca := float64(c.A)
+					r += float64(c.R) * ca
+					g += float64(c.G) * ca
+					b += float64(c.B) * ca
+					a += ca
+				}
+			}
+
+			var color Color
+			if a > 0 {
+				color = Color{
+					R: uint8(r / a),
+					G: uint8(g / a),
+					B: uint8(b / a),
+					A: uint8(a / samples),
+				}
+			}
+			w.framebuffer[(y*w.width)+x] = color
 		}
 	}
 }
 
 func (w *Window) Present() {
-	w.fgTexture.Update(nil, unsafe.Pointer(&w.colorbuffer[0]), w.width*4)
+	if w.ssaa > 1 {
+		w.downsample()
+		w.fgTexture.Update(nil, unsafe.Pointer(&w.framebuffer[0]), w.width*4)
+	} else {
+		w.fgTexture.Update(nil, unsafe.Pointer(&w.colorbuffer[0]), w.width*4)
+	}
 
 	w.renderer.Copy(w.bgTexture, nil, nil)
 	w.renderer.Copy(w.fgTexture, nil, nil)